@@ -12,6 +12,7 @@ import (
 	"github.com/julienschmidt/httprouter"
 	"github.com/fairscape/auth/pkg/auth"
 	"os"
+	"time"
 )
 
 func main() {
@@ -21,13 +22,57 @@ func main() {
 	var globusClientSecret = os.Getenv("GLOBUS_CLIENT_SECRET")
 	var redirectURL = os.Getenv("GLOBUS_REDIRECT_URL")
 
+	var registrationMode = os.Getenv("REGISTRATION_MODE")
+	if registrationMode == "" {
+		registrationMode = auth.RegistrationOff
+	}
+
+	if registrationMode != auth.RegistrationOff && registrationMode != auth.RegistrationAuto && registrationMode != auth.RegistrationApproval {
+		log.Fatalf("REGISTRATION_MODE must be one of off, auto or approval: %s", registrationMode)
+	}
+
 	var scopes = "urn:globus:auth:scope:auth.globus.org:view_identity_set+urn:globus:auth:scope:auth.globus.org:view_identities+openid+email+profile"
 
 	globusClient := auth.GlobusAuthClient{
-		ClientID:     globusClientID,
-		ClientSecret: globusClientSecret,
-		RedirectURL:  redirectURL,
-		Scopes:       scopes,
+		ClientID:         globusClientID,
+		ClientSecret:     globusClientSecret,
+		RedirectURL:      redirectURL,
+		Scopes:           scopes,
+		RegistrationMode: registrationMode,
+	}
+
+	if err := auth.LoadSigningKey(); err != nil {
+		log.Fatalf("Failed to Load Signing Key: %s", err.Error())
+	}
+
+	// without the stored keys a retired or revoked configured key would be trusted again
+	if err := auth.LoadStoredKeys(); err != nil {
+		log.Fatalf("Failed to Load Stored Signing Keys: %s", err.Error())
+	}
+
+	// rotate-keys [--revoke] rotates the signing key for every instance and exits
+	if len(os.Args) > 1 && os.Args[1] == "rotate-keys" {
+		key, err := auth.RotateSigningKey(len(os.Args) > 2 && os.Args[2] == "--revoke")
+		if err != nil {
+			log.Fatalf("Failed to Rotate Signing Key: %s", err.Error())
+		}
+		log.Printf("Rotated Signing Key: %s", key.ID)
+		return
+	}
+
+	go auth.RefreshStoredKeys(time.Minute)
+
+	auth.CreateIndexes()
+
+	err := auth.LoadActionRegistry(os.Getenv("ACTION_REGISTRY"))
+	if err != nil {
+		log.Printf("Failed to Load Action Registry: %s", err.Error())
+	}
+
+	if adminEmail := os.Getenv("ADMIN_EMAIL"); adminEmail != "" {
+		if err := auth.BootstrapAdmin(adminEmail); err != nil {
+			log.Printf("Failed to Bootstrap Admin %s: %s", adminEmail, err.Error())
+		}
 	}
 
 	router := httprouter.New()
@@ -39,23 +84,51 @@ func main() {
 	router.Handler("GET", "/login", http.HandlerFunc(globusClient.GrantHandler))
 	router.Handler("POST", "/logout", http.HandlerFunc(globusClient.RevokeHandler))
 
+	// management routes are wrapped with the callers allowed to use them
+	// public keys for services verifying session tokens
+	router.Handler("GET", "/.well-known/jwks.json", http.HandlerFunc(auth.JWKSHandler))
+	router.Handler("POST", "/keys/rotate", auth.AdminOnly(auth.KeyRotateHandler))
+
 	// user managment routes
-	router.Handler("POST", "/user", http.HandlerFunc(auth.UserCreateHandler))
-	router.Handler("GET", "/user", http.HandlerFunc(auth.UserListHandler))
-	router.Handler("GET", "/user/:userID", http.HandlerFunc(auth.UserGetHandler))
-	router.Handler("DELETE", "/user/:userID", http.HandlerFunc(auth.UserDeleteHandler))
-
-    router.Handler("POST", "/resource", http.HandlerFunc(auth.ResourceCreate))
-    router.Handler("GET", "/resource", http.HandlerFunc(auth.ResourceList))
-    router.Handler("GET", "/resource/*resourceID", http.HandlerFunc(auth.ResourceGet))
-    router.Handler("DELETE", "/resource/*resourceID", http.HandlerFunc(auth.ResourceDelete))
-
-    router.Handler("POST", "/group", http.HandlerFunc(auth.GroupCreate))
-    router.Handler("GET", "/group", http.HandlerFunc(auth.GroupList))
-    router.Handler("GET", "/group/:groupID", http.HandlerFunc(auth.GroupGet))
-    router.Handler("PUT", "/group/:groupID", http.HandlerFunc(auth.GroupUpdate))
-	router.Handler("DELETE", "/group/:groupID", http.HandlerFunc(auth.GroupDelete))
-
-	log.Fatal(http.ListenAndServe(":8080", router))
+	router.Handler("GET", "/me", http.HandlerFunc(auth.UserMeHandler))
+	router.Handler("POST", "/user", auth.AdminOnly(auth.UserCreateHandler))
+	router.Handler("GET", "/user", auth.Curators(auth.UserListHandler))
+	router.Handler("POST", "/user/pending/:userID/approve", auth.AdminOnly(auth.UserApproveHandler))
+	router.Handler("POST", "/user/pending/:userID/reject", auth.AdminOnly(auth.UserRejectHandler))
+	router.Handler("GET", "/user/:userID", auth.SelfOrCurator(auth.UserGetHandler))
+	router.Handler("PATCH", "/user/:userID", auth.SelfOrAdmin(auth.UserPatchHandler))
+	router.Handler("DELETE", "/user/:userID", auth.AdminOnly(auth.UserDeleteHandler))
+	router.Handler("PUT", "/user/:userID/status", auth.AdminOnly(auth.UserStatusHandler))
+	router.Handler("GET", "/user/:userID/challenges", auth.SelfOrCurator(auth.UserChallengesHandler))
+	router.Handler("GET", "/user/:userID/access", auth.SelfOrCurator(auth.UserAccessHandler))
+	router.Handler("GET", "/user/:userID/owned", auth.SelfOrCurator(auth.UserOwnedHandler))
+	router.Handler("GET", "/user/:userID/policies", auth.SelfOrCurator(auth.UserPoliciesHandler))
+	router.Handler("GET", "/user/:userID/identities", auth.SelfOrCurator(auth.UserIdentitiesHandler))
+	router.Handler("DELETE", "/user/:userID/identities/:identityID", auth.SelfOrAdmin(auth.UserUnlinkIdentityHandler))
+
+    router.Handler("POST", "/resource", auth.Authenticated(auth.ResourceCreate))
+    router.Handler("GET", "/resource", auth.Authenticated(auth.ResourceList))
+    router.Handler("GET", "/resource/*resourceID", auth.Authenticated(auth.ResourceGet))
+    router.Handler("DELETE", "/resource/*resourceID", auth.ResourceOwner(auth.ResourceDelete))
+
+    router.Handler("POST", "/group", auth.Curators(auth.GroupCreate))
+    router.Handler("GET", "/group", auth.Authenticated(auth.GroupList))
+    router.Handler("GET", "/group/:groupID", auth.Authenticated(auth.GroupGet))
+    router.Handler("PUT", "/group/:groupID", auth.GroupAdmin(auth.GroupUpdate))
+	router.Handler("DELETE", "/group/:groupID", auth.GroupAdmin(auth.GroupDelete))
+
+	router.Handler("POST", "/policy", auth.PolicyManager(auth.PolicyCreate))
+	router.Handler("GET", "/policy", auth.Authenticated(auth.PolicyList))
+	router.Handler("GET", "/policy/:policyID", auth.Authenticated(auth.PolicyGet))
+	router.Handler("DELETE", "/policy/:policyID", auth.PolicyManager(auth.PolicyDelete))
+
+	router.Handler("POST", "/challenge", auth.Services(auth.ChallengeEvaluate))
+
+	router.Handler("POST", "/action", auth.AdminOnly(auth.ActionCreate))
+	router.Handler("GET", "/action", auth.Authenticated(auth.ActionList))
+	router.Handler("DELETE", "/action/:actionID", auth.AdminOnly(auth.ActionDelete))
+
+	// every handler sees the principal of a valid session token in the request context
+	log.Fatal(http.ListenAndServe(":8080", auth.Authenticate(router)))
 
 }
@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"sort"
+	"time"
+)
+
+// Access lists the actions a user may perform on a resource.
+// Owner reports ownership, which lets the user manage the resource's policies
+// but grants no action on its own, challenges only consider policies
+type Access struct {
+	ID           string   `json:"@id"`
+	ResourceType string   `json:"resourceType,omitempty"`
+	Owner        bool     `json:"owner"`
+	Actions      []string `json:"actions"`
+}
+
+// computeAccess evaluates every registered action on each resource with the same
+// semantics as a challenge made now. Owned resources are listed even when no action is allowed.
+// Conditions on source address or token scopes cannot be known ahead of a request,
+// so access depending on them is not listed
+func computeAccess(u User, resources []Resource, policies []Policy, registry []Action, now time.Time) (access []Access) {
+
+	principals := u.principals()
+
+	for _, r := range resources {
+
+		owner := contains(principals, r.Owner) && r.Owner != "*"
+
+		a := Access{ID: r.ID, ResourceType: r.ResourceType, Owner: owner, Actions: []string{}}
+
+		for _, action := range registry {
+
+			if r.ResourceType != "" && action.ResourceType != r.ResourceType {
+				continue
+			}
+
+			c := Challenge{
+				Principal: u.ID,
+				Action:    action.ID,
+				Object:    r.ID,
+				Context:   RequestContext{Time: now},
+			}
+
+			if authorized, _ := c.evaluate(principals, policies); authorized {
+				a.Actions = append(a.Actions, action.ID)
+			}
+		}
+
+		if len(a.Actions) != 0 || owner {
+			sort.Strings(a.Actions)
+			access = append(access, a)
+		}
+	}
+
+	return
+}
@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestComputeAccess(t *testing.T) {
+
+	var registry []Action
+	for _, s := range defaultServices {
+		registry = append(registry, s.actions()...)
+	}
+
+	u := User{ID: "orcid:1", Groups: []string{"g1"}}
+
+	resources := []Resource{
+		{ID: "ark:99999/owned", ResourceType: "s3:Object", Owner: "orcid:1"},
+		{ID: "ark:88888/unshared", ResourceType: "s3:Object", Owner: "orcid:1"},
+		{ID: "ark:99999/shared", ResourceType: "s3:Object", Owner: "orcid:2"},
+		{ID: "ark:99999/hidden", ResourceType: "s3:Object", Owner: "orcid:2"},
+	}
+
+	policies := []Policy{
+		{ID: "group", Resource: "ark:99999/*", Principal: []string{"g1"}, Effect: effectAllow, Action: []string{"s3:Get*"}},
+		{ID: "deny", Resource: "ark:99999/hidden", Principal: []string{"*"}, Effect: effectDeny, Action: []string{"*"}},
+		{ID: "ownerDeny", Resource: "ark:99999/owned", Principal: []string{"orcid:1"}, Effect: effectDeny, Action: []string{"s3:CopyObject"}},
+	}
+
+	access := computeAccess(u, resources, policies, registry, time.Now())
+
+	if len(access) != 3 {
+		t.Fatalf("Expected Access to Three Resources: %+v", access)
+	}
+
+	owned, unshared, shared := access[0], access[1], access[2]
+
+	// ownership grants no action a challenge would not allow
+	if !owned.Owner || len(owned.Actions) != 1 || owned.Actions[0] != "s3:GetObject" {
+		t.Errorf("Owned Resource Access Incorrect: %+v", owned)
+	}
+
+	if !unshared.Owner || len(unshared.Actions) != 0 {
+		t.Errorf("Owned Resource without Policies Incorrect: %+v", unshared)
+	}
+
+	if shared.Owner || len(shared.Actions) != 1 || shared.Actions[0] != "s3:GetObject" {
+		t.Errorf("Shared Resource Access Incorrect: %+v", shared)
+	}
+
+}
@@ -0,0 +1,346 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	bson "go.mongodb.org/mongo-driver/bson"
+	mongo "go.mongodb.org/mongo-driver/mongo"
+	options "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Action is a registered operation of a service on one of its resource types
+type Action struct {
+	ID           string `json:"@id" bson:"@id"`
+	Type         string `json:"@type" bson:"@type"`
+	Service      string `json:"service" bson:"service"`
+	ResourceType string `json:"resourceType" bson:"resourceType"`
+}
+
+// ServiceDefinition is the configuration format for registering the actions of a service
+type ServiceDefinition struct {
+	Name      string               `json:"name"`
+	Resources []ResourceDefinition `json:"resources"`
+}
+
+// ResourceDefinition lists the actions valid on one resource type of a service
+type ResourceDefinition struct {
+	Type    string   `json:"type"`
+	Actions []string `json:"actions"`
+}
+
+// defaultServices are registered when no registry configuration is supplied
+var defaultServices = []ServiceDefinition{
+	{
+		Name: "s3",
+		Resources: []ResourceDefinition{
+			{Type: "s3:Object", Actions: []string{"s3:GetObject", "s3:UpdateObject", "s3:CopyObject"}},
+			{Type: "s3:Bucket", Actions: []string{"s3:ListObjects", "s3:CreateObject", "s3:DeleteBucket"}},
+		},
+	},
+	{
+		Name: "mds",
+		Resources: []ResourceDefinition{
+			{Type: "mds:Identifier", Actions: []string{"mds:GetIdentifier", "mds:UpdateIdentifier", "mds:DeleteIdentifier"}},
+			{Type: "mds:Namespace", Actions: []string{"mds:CreateIdentifier", "mds:GetNamespace", "mds:CreateNamespace", "mds:DeleteNamespace"}},
+		},
+	},
+}
+
+// actions flattens the service definition into the registered actions
+func (s ServiceDefinition) actions() (a []Action) {
+
+	for _, r := range s.Resources {
+		for _, action := range r.Actions {
+			a = append(a, Action{ID: action, Type: typeAction, Service: s.Name, ResourceType: r.Type})
+		}
+	}
+
+	return
+}
+
+func (a Action) validate() error {
+
+	if a.ID == "" {
+		return fmt.Errorf("%w: Action missing @id", errModelMissingField)
+	}
+
+	if a.Service == "" {
+		return fmt.Errorf("%w: Action missing Service", errModelMissingField)
+	}
+
+	if a.ResourceType == "" {
+		return fmt.Errorf("%w: Action missing ResourceType", errModelMissingField)
+	}
+
+	if !strings.HasPrefix(a.ID, a.Service+":") || strings.Contains(a.ID, "*") {
+		return fmt.Errorf("%w: Action %s must be named %s:<Operation>", errModelFieldValidation, a.ID, a.Service)
+	}
+
+	return nil
+}
+
+// upsert registers the action, replacing any existing registration
+func (a *Action) upsert() (err error) {
+
+	a.Type = typeAction
+
+	err = a.validate()
+	if err != nil {
+		return
+	}
+
+	ctx, cancel, client, err := connectMongo()
+	defer cancel()
+
+	if err != nil {
+		return fmt.Errorf("%w: %s", errMongoClient, err.Error())
+	}
+
+	collection := client.Database(mongoDatabase).Collection(mongoCollection)
+
+	_, err = collection.ReplaceOne(ctx,
+		bson.D{{"@id", a.ID}, {"@type", typeAction}},
+		a,
+		options.Replace().SetUpsert(true),
+	)
+
+	return
+}
+
+func (a *Action) delete() (err error) {
+
+	ctx, cancel, client, err := connectMongo()
+	defer cancel()
+
+	if err != nil {
+		return fmt.Errorf("%w: %s", errMongoClient, err.Error())
+	}
+
+	collection := client.Database(mongoDatabase).Collection(mongoCollection)
+	err = collection.FindOneAndDelete(ctx, bson.D{{"@id", a.ID}, {"@type", typeAction}}).Decode(&a)
+
+	if err == mongo.ErrNoDocuments {
+		err = errNoDocument
+	}
+
+	return
+}
+
+// listActions returns the registered actions, optionally only those of one service
+func listActions(service string) (a []Action, err error) {
+
+	mongoCtx, cancel, client, err := connectMongo()
+	defer cancel()
+
+	if err != nil {
+		err = fmt.Errorf("%w: %s", errMongoClient, err.Error())
+		return
+	}
+
+	collection := client.Database(mongoDatabase).Collection(mongoCollection)
+
+	query := bson.D{{"@type", typeAction}}
+	if service != "" {
+		query = append(query, bson.E{"service", service})
+	}
+
+	cur, err := collection.Find(mongoCtx, query)
+	if err != nil {
+		err = fmt.Errorf("%w: %s", errMongoQuery, err.Error())
+		return
+	}
+	defer cur.Close(mongoCtx)
+
+	err = cur.All(mongoCtx, &a)
+	if err != nil {
+		err = fmt.Errorf("%w: %s", errMongoDecode, err.Error())
+	}
+
+	return
+}
+
+// LoadActionRegistry registers the services described in the JSON file at path,
+// with an empty path the default services are registered if the registry is empty
+func LoadActionRegistry(path string) error {
+
+	services := defaultServices
+
+	if path == "" {
+		existing, err := listActions("")
+		if err != nil {
+			return err
+		}
+
+		if len(existing) != 0 {
+			return nil
+		}
+	} else {
+		config, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("ActionRegistry: Failed to Read %s: %w", path, err)
+		}
+
+		err = json.Unmarshal(config, &services)
+		if err != nil {
+			return fmt.Errorf("%w: %s", errJSONUnmarshal, err.Error())
+		}
+	}
+
+	for _, s := range services {
+		for _, a := range s.actions() {
+			if err := a.upsert(); err != nil {
+				return err
+			}
+		}
+	}
+
+	log.Printf("ActionRegistry: Registered %d Services", len(services))
+
+	return nil
+}
+
+// validatePolicyActions checks each action of the policy against the registry
+// an exact action must be registered and a wildcard must match a registered action,
+// when the policy targets a single resource of a known type the actions must apply to that type
+func validatePolicyActions(p Policy, registry []Action, resourceType string) error {
+
+	checkType := resourceType != "" && !isNamespacePattern(p.Resource)
+
+	for _, pattern := range p.Action {
+
+		if pattern == "*" {
+			continue
+		}
+
+		known, applicable := false, false
+
+		for _, a := range registry {
+			if matchAction(pattern, a.ID) {
+				known = true
+				applicable = applicable || a.ResourceType == resourceType
+			}
+		}
+
+		if !known {
+			return fmt.Errorf("%w: Unknown Action %s", errModelFieldValidation, pattern)
+		}
+
+		if checkType && !applicable {
+			return fmt.Errorf("%w: Action %s does not apply to %s resources", errModelFieldValidation, pattern, resourceType)
+		}
+	}
+
+	return nil
+}
+
+// ActionCreate is the http handler for registering an action
+// POST /action
+func ActionCreate(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/ld+json")
+
+	var a Action
+
+	requestBody, err := ioutil.ReadAll(r.Body)
+
+	if err != nil {
+		w.WriteHeader(400)
+		fmt.Fprintf(w, `{"error": "Unable to Read Request Body"}`)
+		return
+	}
+
+	if !json.Valid(requestBody) {
+		w.WriteHeader(400)
+		fmt.Fprintf(w, `{"error": "Invalid JSON Submitted"}`)
+		return
+	}
+
+	err = json.Unmarshal(requestBody, &a)
+
+	if err != nil {
+		w.WriteHeader(400)
+		fmt.Fprintf(w, `{"message": "Failed to Unmarshal Request JSON", "error": "%s"}`, err.Error())
+		return
+	}
+
+	err = a.upsert()
+
+	if errors.Is(err, errModelMissingField) || errors.Is(err, errModelFieldValidation) {
+		w.WriteHeader(400)
+		fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+		return
+	}
+
+	if err != nil {
+		w.WriteHeader(500)
+		fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+		return
+	}
+
+	responseBody, _ := json.Marshal(a)
+	w.WriteHeader(201)
+	w.Write(responseBody)
+	return
+
+}
+
+// ActionList is the http handler for listing registered actions
+// GET /action?service=<service>
+func ActionList(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/ld+json")
+
+	actions, err := listActions(r.URL.Query().Get("service"))
+
+	if err != nil {
+		w.WriteHeader(500)
+		fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+		return
+	}
+
+	if actions == nil {
+		actions = []Action{}
+	}
+
+	responseBody, _ := json.Marshal(actions)
+	w.WriteHeader(200)
+	w.Write(responseBody)
+	return
+
+}
+
+// ActionDelete is the http handler for removing an action from the registry
+// DELETE /action/:actionID
+func ActionDelete(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/ld+json")
+
+	var a Action
+
+	params := httprouter.ParamsFromContext(r.Context())
+	a.ID = params.ByName("actionID")
+
+	err := a.delete()
+
+	if errors.Is(err, errNoDocument) {
+		w.WriteHeader(404)
+		fmt.Fprintf(w, `{"error": "Action Not Found", "@id": "%s"}`, a.ID)
+		return
+	}
+
+	if err != nil {
+		w.WriteHeader(500)
+		fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+		return
+	}
+
+	responseBody, _ := json.Marshal(a)
+	w.WriteHeader(200)
+	w.Write(responseBody)
+	return
+
+}
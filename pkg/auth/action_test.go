@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"testing"
+)
+
+func TestValidatePolicyActions(t *testing.T) {
+
+	var registry []Action
+	for _, s := range defaultServices {
+		registry = append(registry, s.actions()...)
+	}
+
+	policy := func(resource string, actions ...string) Policy {
+		return Policy{Resource: resource, Principal: []string{"*"}, Effect: effectAllow, Action: actions}
+	}
+
+	t.Run("Registered", func(t *testing.T) {
+		err := validatePolicyActions(policy("ark:99999/test", "s3:GetObject", "mds:*", "*"), registry, "")
+		if err != nil {
+			t.Fatalf("Registered Actions Rejected: %s", err.Error())
+		}
+	})
+
+	t.Run("Typo", func(t *testing.T) {
+		err := validatePolicyActions(policy("ark:99999/test", "s3:GetObjcet"), registry, "")
+		if err == nil {
+			t.Fatalf("Unknown Action Accepted")
+		}
+	})
+
+	t.Run("UnmatchedWildcard", func(t *testing.T) {
+		err := validatePolicyActions(policy("ark:99999/test", "hdfs:*"), registry, "")
+		if err == nil {
+			t.Fatalf("Wildcard for Unregistered Service Accepted")
+		}
+	})
+
+	t.Run("ObjectActionOnBucket", func(t *testing.T) {
+		err := validatePolicyActions(policy("ark:99999/bucket", "s3:GetObject"), registry, "s3:Bucket")
+		if err == nil {
+			t.Fatalf("Object Action on Bucket Accepted")
+		}
+	})
+
+	t.Run("BucketActionOnBucket", func(t *testing.T) {
+		err := validatePolicyActions(policy("ark:99999/bucket", "s3:ListObjects", "s3:*"), registry, "s3:Bucket")
+		if err != nil {
+			t.Fatalf("Bucket Action on Bucket Rejected: %s", err.Error())
+		}
+	})
+
+	t.Run("ObjectActionBeneathNamespace", func(t *testing.T) {
+		err := validatePolicyActions(policy("ark:99999/*", "mds:GetIdentifier"), registry, "mds:Namespace")
+		if err != nil {
+			t.Fatalf("Identifier Action on Namespace Pattern Rejected: %s", err.Error())
+		}
+	})
+
+}
+
+func TestActionValidate(t *testing.T) {
+
+	if err := (Action{ID: "hdfs:ReadFile", Service: "hdfs", ResourceType: "hdfs:File"}).validate(); err != nil {
+		t.Fatalf("Valid Action Rejected: %s", err.Error())
+	}
+
+	if err := (Action{ID: "ReadFile", Service: "hdfs", ResourceType: "hdfs:File"}).validate(); err == nil {
+		t.Fatalf("Action without Service Prefix Accepted")
+	}
+
+}
@@ -0,0 +1,339 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	mongo "go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	errTokenMissing = errors.New("Missing Bearer Token")
+	errTokenInvalid = errors.New("Invalid Token")
+)
+
+type contextKey string
+
+// principalKey holds the authenticated principal in the request context
+const principalKey contextKey = "principal"
+
+// Principal is the authenticated user of a request and the expiry of their session token
+type Principal struct {
+	User      User
+	ExpiresAt time.Time
+}
+
+// PrincipalFromContext returns the principal Authenticate placed in the request context
+func PrincipalFromContext(ctx context.Context) (p Principal, ok bool) {
+	p, ok = ctx.Value(principalKey).(Principal)
+	return
+}
+
+// callerFromContext returns the authenticated user of the request
+func callerFromContext(ctx context.Context) (u User, ok bool) {
+	p, ok := PrincipalFromContext(ctx)
+	return p.User, ok
+}
+
+// authenticate verifies the session token of the request and loads the current record of its user,
+// so role, group and status changes apply without waiting for the token to expire
+func authenticate(r *http.Request) (p Principal, err error) {
+
+	tokenString := requestToken(r)
+	if tokenString == "" {
+		return p, errTokenMissing
+	}
+
+	claims, err := verifyToken(tokenString)
+	if err != nil {
+		return
+	}
+
+	p.ExpiresAt = time.Unix(claims.ExpiresAt, 0).UTC()
+	p.User.ID = claims.Subject
+	err = p.User.get()
+
+	if err == mongo.ErrNoDocuments {
+		return p, fmt.Errorf("%w: User %s Not Found", errTokenInvalid, claims.Subject)
+	}
+
+	if err != nil {
+		return
+	}
+
+	// tokens issued before a suspension are refused along with new logins
+	if !p.User.active() {
+		return p, fmt.Errorf("%w: User is %s", errTokenInvalid, p.User.Status)
+	}
+
+	return
+}
+
+// Authenticate places the principal of a valid session token in the context of every request.
+// Requests without a valid token continue unauthenticated, the routes requiring a caller are
+// wrapped to refuse them, and tokens from other issuers such as globus reach the handlers unchanged
+func Authenticate(next http.Handler) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if p, err := authenticate(r); err == nil {
+			r = r.WithContext(context.WithValue(r.Context(), principalKey, p))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// hasRole reports if the user holds any of the roles, ignoring case
+func (u User) hasRole(roles ...string) bool {
+
+	for _, role := range roles {
+		if strings.EqualFold(u.Role, role) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// authorize runs the handler only when allowed reports the authenticated caller may make the request,
+// responding 401 without a valid token and 403 when the caller is not allowed
+func authorize(next http.HandlerFunc, allowed func(caller User, r *http.Request) (bool, error)) http.HandlerFunc {
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		caller, ok := callerFromContext(r.Context())
+
+		if !ok {
+			p, err := authenticate(r)
+
+			if errors.Is(err, errTokenMissing) || errors.Is(err, errTokenInvalid) {
+				w.Header().Set("Content-Type", "application/ld+json")
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				w.WriteHeader(401)
+				fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+				return
+			}
+
+			if err != nil {
+				w.Header().Set("Content-Type", "application/ld+json")
+				w.WriteHeader(500)
+				fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+				return
+			}
+
+			caller = p.User
+			r = r.WithContext(context.WithValue(r.Context(), principalKey, p))
+		}
+
+		permitted, err := allowed(caller, r)
+
+		if err != nil {
+			w.Header().Set("Content-Type", "application/ld+json")
+			w.WriteHeader(500)
+			fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+			return
+		}
+
+		if !permitted {
+			w.Header().Set("Content-Type", "application/ld+json")
+			w.WriteHeader(403)
+			fmt.Fprintf(w, `{"error": "Forbidden", "@id": "%s"}`, caller.ID)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// requireRole allows callers holding any of the roles
+func requireRole(next http.HandlerFunc, roles ...string) http.HandlerFunc {
+	return authorize(next, func(caller User, r *http.Request) (bool, error) {
+		return caller.hasRole(roles...), nil
+	})
+}
+
+// Authenticated allows any user with a valid token
+func Authenticated(next http.HandlerFunc) http.HandlerFunc {
+	return authorize(next, func(caller User, r *http.Request) (bool, error) {
+		return true, nil
+	})
+}
+
+// AdminOnly allows admins
+func AdminOnly(next http.HandlerFunc) http.HandlerFunc {
+	return requireRole(next, roleAdmin)
+}
+
+// Curators allows admins and curators
+func Curators(next http.HandlerFunc) http.HandlerFunc {
+	return requireRole(next, roleAdmin, roleCurator)
+}
+
+// Services allows admins and service accounts
+func Services(next http.HandlerFunc) http.HandlerFunc {
+	return requireRole(next, roleAdmin, roleService)
+}
+
+// SelfOrCurator allows users to read their own :userID, and admins and curators to read any
+func SelfOrCurator(next http.HandlerFunc) http.HandlerFunc {
+	return authorize(next, func(caller User, r *http.Request) (bool, error) {
+		userID := httprouter.ParamsFromContext(r.Context()).ByName("userID")
+		return caller.ID == userID || caller.hasRole(roleAdmin, roleCurator), nil
+	})
+}
+
+// SelfOrAdmin allows users to change their own :userID, and admins to change any
+func SelfOrAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return authorize(next, func(caller User, r *http.Request) (bool, error) {
+		userID := httprouter.ParamsFromContext(r.Context()).ByName("userID")
+		return caller.ID == userID || caller.hasRole(roleAdmin), nil
+	})
+}
+
+// GroupAdmin allows the admin of :groupID to manage only their own group, and admins to manage any
+func GroupAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return authorize(next, func(caller User, r *http.Request) (bool, error) {
+
+		if caller.hasRole(roleAdmin) {
+			return true, nil
+		}
+
+		g := Group{ID: httprouter.ParamsFromContext(r.Context()).ByName("groupID")}
+		err := g.get()
+
+		if err == mongo.ErrNoDocuments {
+			return false, nil
+		}
+
+		return err == nil && g.Admin == caller.ID, err
+	})
+}
+
+// ownsResource reports if the caller owns the resource directly or through one of their groups
+func (u User) ownsResource(resourceID string) (bool, error) {
+
+	resource := Resource{ID: resourceID}
+	err := resource.get()
+
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return contains(u.owners(), resource.Owner), nil
+}
+
+// ResourceOwner allows the owner of *resourceID to manage only their own resource,
+// and admins and curators to manage any
+func ResourceOwner(next http.HandlerFunc) http.HandlerFunc {
+	return authorize(next, func(caller User, r *http.Request) (bool, error) {
+
+		if caller.hasRole(roleAdmin, roleCurator) {
+			return true, nil
+		}
+
+		resourceID := strings.TrimPrefix(httprouter.ParamsFromContext(r.Context()).ByName("resourceID"), "/")
+		return caller.ownsResource(resourceID)
+	})
+}
+
+// ownerFor is the owner recorded for a resource the user creates,
+// only admins and curators may create resources on behalf of another owner
+func (u User) ownerFor(requested string) string {
+
+	if requested != "" && u.hasRole(roleAdmin, roleCurator) {
+		return requested
+	}
+
+	return u.ID
+}
+
+// mayRegister reports if the user may create a resource for the identifier. Admins and curators
+// may register any, others only identifiers no policy applies to and no namespace encloses,
+// or those beneath a namespace they own. Namespaces are only registered by admins and curators
+func (u User) mayRegister(id string, namespaces []Resource, governed bool) bool {
+
+	if u.hasRole(roleAdmin, roleCurator) {
+		return true
+	}
+
+	if isNamespacePattern(id) {
+		return false
+	}
+
+	for _, namespace := range namespaces {
+		if contains(u.owners(), namespace.Owner) {
+			return true
+		}
+	}
+
+	return len(namespaces) == 0 && !governed
+}
+
+// readsPolicy reports if the user may read the policy, admins and curators read any,
+// others those naming one of their principals or on a resource they own
+func (u User) readsPolicy(p Policy) (bool, error) {
+
+	if u.hasRole(roleAdmin, roleCurator) || containsAny(p.Principal, u.principals()) {
+		return true, nil
+	}
+
+	return u.ownsResource(p.Resource)
+}
+
+// PolicyManager allows resource owners to create and delete policies on their own resources,
+// and admins and curators to manage any policy. A created policy's resource is read from the body.
+// Namespace policies reach every identifier beneath them so only admins and curators manage them
+func PolicyManager(next http.HandlerFunc) http.HandlerFunc {
+	return authorize(next, func(caller User, r *http.Request) (bool, error) {
+
+		if caller.hasRole(roleAdmin, roleCurator) {
+			return true, nil
+		}
+
+		if policyID := httprouter.ParamsFromContext(r.Context()).ByName("policyID"); policyID != "" {
+			p := Policy{ID: policyID}
+			err := p.get()
+
+			if err == errNoDocument {
+				return false, nil
+			}
+
+			if err != nil {
+				return false, err
+			}
+
+			if isNamespacePattern(p.Resource) {
+				return false, nil
+			}
+
+			return caller.ownsResource(p.Resource)
+		}
+
+		// restore the body for the handler once the resource is read
+		requestBody, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return false, nil
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(requestBody))
+
+		var p Policy
+		if json.Unmarshal(requestBody, &p) != nil || p.Resource == "" || isNamespacePattern(p.Resource) {
+			return false, nil
+		}
+
+		return caller.ownsResource(p.Resource)
+	})
+}
@@ -0,0 +1,252 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestAuthorize(t *testing.T) {
+
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+	}
+
+	// requests carry the caller and route params as the router and authentication would
+	request := func(caller *User, userID string) *http.Request {
+		r := httptest.NewRequest("GET", "http://localhost:8080/user/"+userID, nil)
+		ctx := context.WithValue(r.Context(), httprouter.ParamsKey, httprouter.Params{{Key: "userID", Value: userID}})
+
+		if caller != nil {
+			ctx = context.WithValue(ctx, principalKey, Principal{User: *caller})
+		}
+
+		return r.WithContext(ctx)
+	}
+
+	admin := User{ID: "admin", Role: "Admin"}
+	curator := User{ID: "curator", Role: roleCurator}
+	member := User{ID: "member", Role: roleMember}
+
+	for name, tc := range map[string]struct {
+		handler http.HandlerFunc
+		request *http.Request
+		code    int
+	}{
+		"MissingToken":         {AdminOnly(ok), request(nil, "member"), 401},
+		"AdminOnlyAdmin":       {AdminOnly(ok), request(&admin, "member"), 200},
+		"AdminOnlyCurator":     {AdminOnly(ok), request(&curator, "member"), 403},
+		"CuratorsCurator":      {Curators(ok), request(&curator, "member"), 200},
+		"ServicesMember":       {Services(ok), request(&member, "member"), 403},
+		"SelfOrCuratorSelf":    {SelfOrCurator(ok), request(&member, "member"), 200},
+		"SelfOrCuratorOther":   {SelfOrCurator(ok), request(&member, "curator"), 403},
+		"SelfOrCuratorCurator": {SelfOrCurator(ok), request(&curator, "member"), 200},
+		"SelfOrAdminCurator":   {SelfOrAdmin(ok), request(&curator, "member"), 403},
+		"Authenticated":        {Authenticated(ok), request(&member, "admin"), 200},
+	} {
+		t.Run(name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			tc.handler(rr, tc.request)
+
+			if rr.Code != tc.code {
+				t.Errorf("StatusCode: %d not %d\nBody: %s", rr.Code, tc.code, rr.Body.String())
+			}
+		})
+	}
+
+}
+
+func TestOwnershipEscalation(t *testing.T) {
+
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+	}
+
+	withCaller := func(r *http.Request, caller User) *http.Request {
+		return r.WithContext(context.WithValue(r.Context(), principalKey, Principal{User: caller}))
+	}
+
+	curator := User{ID: "curator", Role: roleCurator}
+	member := User{ID: "member", Role: roleMember}
+
+	// a member creating a resource is recorded as its owner whatever the body names
+	t.Run("ResourceOwner", func(t *testing.T) {
+		if owner := member.ownerFor("admin"); owner != member.ID {
+			t.Errorf("Member Created a Resource Owned by %s", owner)
+		}
+
+		if owner := curator.ownerFor("member"); owner != "member" {
+			t.Errorf("Curator could not Create a Resource for %s", owner)
+		}
+	})
+
+	t.Run("RegisterIdentifier", func(t *testing.T) {
+		owned := []Resource{{ID: "ark:99999/*", Owner: member.ID}}
+		foreign := []Resource{{ID: "ark:99999/*", Owner: "team"}}
+
+		for name, tc := range map[string]struct {
+			caller     User
+			id         string
+			namespaces []Resource
+			governed   bool
+			allowed    bool
+		}{
+			"Unclaimed":        {member, "ark:99999/new", nil, false, true},
+			"Governed":         {member, "ark:99999/x", nil, true, false},
+			"ForeignNamespace": {member, "ark:99999/x", foreign, false, false},
+			"OwnedNamespace":   {member, "ark:99999/x", owned, true, true},
+			"Namespace":        {member, "ark:77777/*", nil, false, false},
+			"CuratorGoverned":  {curator, "ark:99999/x", foreign, true, true},
+		} {
+			t.Run(name, func(t *testing.T) {
+				if tc.caller.mayRegister(tc.id, tc.namespaces, tc.governed) != tc.allowed {
+					t.Errorf("Registering %s should be %t", tc.id, tc.allowed)
+				}
+			})
+		}
+	})
+
+	// owning a namespace resource must not let a member grant themselves the namespace
+	namespacePolicy := `{"resource": "ark:99999/*", "principal": ["member"], "effect": "Allow", "action": ["*"]}`
+
+	t.Run("NamespacePolicyMember", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		PolicyManager(ok)(rr, withCaller(httptest.NewRequest("POST", "http://localhost:8080/policy", strings.NewReader(namespacePolicy)), member))
+
+		if rr.Code != 403 {
+			t.Errorf("StatusCode: %d \nBody: %s", rr.Code, rr.Body.String())
+		}
+	})
+
+	t.Run("NamespacePolicyCurator", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		PolicyManager(ok)(rr, withCaller(httptest.NewRequest("POST", "http://localhost:8080/policy", strings.NewReader(namespacePolicy)), curator))
+
+		if rr.Code != 200 {
+			t.Errorf("StatusCode: %d \nBody: %s", rr.Code, rr.Body.String())
+		}
+	})
+
+	t.Run("ReadPolicy", func(t *testing.T) {
+		named := Policy{Resource: "ark:99999/x", Principal: []string{"group:g1", "member"}}
+		foreign := Policy{Resource: "ark:99999/x", Principal: []string{"other"}}
+
+		if readable, _ := member.readsPolicy(named); !readable {
+			t.Errorf("Member cannot Read a Policy Naming them")
+		}
+
+		if readable, _ := curator.readsPolicy(foreign); !readable {
+			t.Errorf("Curator cannot Read a Policy")
+		}
+
+		visible := policyFilter{Visible: &policyVisibility{Principals: member.principals(), Owned: []string{"ark:99999/owned"}}}
+		if query := visible.query(); len(query) != 2 || query[1].Key != "$or" {
+			t.Errorf("Member Policy Listing not Restricted: %+v", query)
+		}
+	})
+
+	t.Run("OtherUsersPolicies", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		PolicyList(rr, withCaller(httptest.NewRequest("GET", "http://localhost:8080/policy?user=curator", nil), member))
+
+		if rr.Code != 403 {
+			t.Errorf("StatusCode: %d \nBody: %s", rr.Code, rr.Body.String())
+		}
+	})
+
+}
+
+func TestParseToken(t *testing.T) {
+
+	u := User{ID: "member", Role: roleMember, Groups: []string{"g1", "g2"}}
+
+	if err := u.newSession(); err != nil {
+		t.Fatalf("Failed Creating Session: %s", err.Error())
+	}
+
+	claimed, err := parseToken(u.AccessToken)
+	if err != nil {
+		t.Fatalf("Valid Token Rejected: %s", err.Error())
+	}
+
+	if claimed.ID != u.ID || len(claimed.Groups) != 2 {
+		t.Errorf("Token Claims Incorrect: %+v", claimed)
+	}
+
+	if _, err := parseToken(u.AccessToken + "x"); err == nil {
+		t.Errorf("Tampered Token Accepted")
+	}
+
+}
+
+func TestVerifyToken(t *testing.T) {
+
+	sign := func(claims UserTokenClaims) string {
+		token, err := activeKey.sign(claims)
+		if err != nil {
+			t.Fatalf("Failed Signing Token: %s", err.Error())
+		}
+		return token
+	}
+
+	now := time.Now()
+	valid := jwt.StandardClaims{
+		Subject:   "member",
+		Issuer:    tokenIssuer,
+		Audience:  tokenAudience,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(time.Hour).Unix(),
+	}
+
+	if _, err := verifyToken(sign(UserTokenClaims{StandardClaims: valid})); err != nil {
+		t.Fatalf("Valid Token Rejected: %s", err.Error())
+	}
+
+	expired, noExpiry, audience, issuer := valid, valid, valid, valid
+	expired.ExpiresAt = now.Add(-time.Hour).Unix()
+	noExpiry.ExpiresAt = 0
+	audience.Audience = "https://example.org"
+	issuer.Issuer = "https://example.org"
+
+	for name, claims := range map[string]jwt.StandardClaims{
+		"Expired":  expired,
+		"NoExpiry": noExpiry,
+		"Audience": audience,
+		"Issuer":   issuer,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := verifyToken(sign(UserTokenClaims{StandardClaims: claims}))
+			if !errors.Is(err, errTokenInvalid) {
+				t.Errorf("Invalid Token Accepted: %v", err)
+			}
+		})
+	}
+
+	t.Run("AlgNone", func(t *testing.T) {
+		token, _ := jwt.NewWithClaims(jwt.SigningMethodNone, UserTokenClaims{StandardClaims: valid}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+		if _, err := verifyToken(token); err == nil {
+			t.Errorf("Unsigned Token Accepted")
+		}
+	})
+
+	t.Run("MiddlewareWithoutToken", func(t *testing.T) {
+		var authenticated bool
+		handler := Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, authenticated = PrincipalFromContext(r.Context())
+		}))
+
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://localhost:8080/login", nil))
+
+		if authenticated {
+			t.Errorf("Principal Set Without Token")
+		}
+	})
+
+}
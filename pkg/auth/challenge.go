@@ -0,0 +1,599 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	bson "go.mongodb.org/mongo-driver/bson"
+	mongo "go.mongodb.org/mongo-driver/mongo"
+	options "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxBatchChallenges bounds the number of challenges evaluated in one request
+const maxBatchChallenges = 1000
+
+// Challenge asks whether a principal may perform an action on an object
+// once decided it is persisted as the audit record of the decision
+type Challenge struct {
+	ID        string `json:"@id,omitempty" bson:"@id"`
+	Type      string `json:"@type,omitempty" bson:"@type"`
+	Principal string `json:"principal" bson:"principal"`
+	Action    string `json:"action" bson:"action"`
+	Object    string `json:"object" bson:"object"`
+	// Service names the calling service as it declares itself
+	Service string `json:"service,omitempty" bson:"service"`
+	// Caller is the authenticated user or service account that made the challenge
+	Caller string `json:"caller,omitempty" bson:"caller,omitempty"`
+	// Context is optional, missing values are taken from the http request
+	Context RequestContext `json:"context" bson:"context"`
+
+	Policies   []string  `json:"policies,omitempty" bson:"policies"`
+	Authorized bool      `json:"authorized" bson:"authorized"`
+	Timestamp  time.Time `json:"timestamp" bson:"timestamp"`
+}
+
+// Decision is the outcome of evaluating a challenge
+type Decision struct {
+	Timestamp   time.Time    `json:"timestamp"`
+	Authorized  bool         `json:"authorized"`
+	Explanation *Explanation `json:"explanation,omitempty"`
+}
+
+// Explanation details how a decision was reached when explain mode is requested
+type Explanation struct {
+	Principals []string           `json:"principals"`
+	Policies   []PolicyEvaluation `json:"policies"`
+	Deciding   *Policy            `json:"deciding"`
+}
+
+// PolicyEvaluation records which parts of a candidate policy matched the challenge
+type PolicyEvaluation struct {
+	Policy    Policy `json:"policy"`
+	Resource  bool   `json:"resource"`
+	Action    bool   `json:"action"`
+	Principal bool   `json:"principal"`
+	Condition bool   `json:"condition"`
+	Matched   bool   `json:"matched"`
+}
+
+func (c Challenge) validate() error {
+
+	if c.Principal == "" {
+		return fmt.Errorf("%w: Challenge missing Principal", errModelMissingField)
+	}
+
+	if c.Action == "" {
+		return fmt.Errorf("%w: Challenge missing Action", errModelMissingField)
+	}
+
+	if c.Object == "" {
+		return fmt.Errorf("%w: Challenge missing Object", errModelMissingField)
+	}
+
+	return nil
+}
+
+// resolvePrincipals expands each principal into the user and all their groups,
+// an unknown principal is still evaluated against policies naming it or everyone
+func resolvePrincipals(ids []string) (resolved map[string][]string, err error) {
+
+	users, err := listUsersByID(ids)
+	if err != nil {
+		return
+	}
+
+	resolved = make(map[string][]string, len(ids))
+
+	for _, id := range ids {
+		resolved[id] = []string{id, "*"}
+	}
+
+	// a user may be named by internal id or orcid iD,
+	// users who are not active resolve to no principals so every challenge is denied
+	for _, u := range users {
+		for _, id := range ids {
+			if id == u.ID || (u.ORCID != "" && id == orcidPrefix+u.ORCID) {
+				resolved[id] = []string{}
+
+				if u.active() {
+					resolved[id] = u.principals()
+				}
+			}
+		}
+	}
+
+	return
+}
+
+// matches reports if the policy applies to this challenge for the given principals
+func (c Challenge) matches(p Policy, principals []string) bool {
+	return c.inspect(p, principals).Matched
+}
+
+// inspect evaluates each part of the policy against the challenge
+func (c Challenge) inspect(p Policy, principals []string) (e PolicyEvaluation) {
+
+	e.Policy = p
+	e.Resource = matchResource(p.Resource, c.Object)
+	e.Principal = containsAny(p.Principal, principals)
+	e.Action = matchAnyAction(p.Action, c.Action)
+	e.Condition = p.Condition.satisfied(c.Context)
+	e.Matched = e.Resource && e.Principal && e.Action && e.Condition
+
+	return
+}
+
+// matching lists every candidate policy that applies to this challenge
+func (c Challenge) matching(principals []string, policies []Policy) (m []Policy) {
+
+	for _, p := range policies {
+		if c.matches(p, principals) {
+			m = append(m, p)
+		}
+	}
+
+	return
+}
+
+// evaluate applies deny-overrides-allow semantics over the candidate policies
+// at least one matching Allow and no matching Deny is required to authorize,
+// so an explicit Deny on an identifier or any namespace above it always wins.
+// The deciding policy is the most specific matching policy with the winning effect
+func (c Challenge) evaluate(principals []string, policies []Policy) (authorized bool, deciding *Policy) {
+
+	var allow, deny *Policy
+
+	matched := c.matching(principals, policies)
+
+	for i := range matched {
+		p := &matched[i]
+
+		switch p.Effect {
+		case effectDeny:
+			if deny == nil || resourceSpecificity(p.Resource) > resourceSpecificity(deny.Resource) {
+				deny = p
+			}
+		case effectAllow:
+			if allow == nil || resourceSpecificity(p.Resource) > resourceSpecificity(allow.Resource) {
+				allow = p
+			}
+		}
+	}
+
+	if deny != nil {
+		return false, deny
+	}
+
+	return allow != nil, allow
+}
+
+// explain reports every candidate policy and the one deciding the outcome
+func (c Challenge) explain(principals []string, policies []Policy) *Explanation {
+
+	e := &Explanation{
+		Principals: principals,
+		Policies:   make([]PolicyEvaluation, len(policies)),
+	}
+
+	for i, p := range policies {
+		e.Policies[i] = c.inspect(p, principals)
+	}
+
+	_, e.Deciding = c.evaluate(principals, policies)
+
+	return e
+}
+
+func (c Challenge) decide(explain bool) (d Decision, err error) {
+
+	decisions, err := decideBatch([]Challenge{c}, explain)
+	if err != nil {
+		return
+	}
+
+	return decisions[0], nil
+}
+
+// decideBatch evaluates every challenge with a single user lookup and a single
+// policy query, returning the decisions in the order the challenges were given.
+// Every decision is recorded before it is returned.
+// When explaining, policies naming any principal are fetched so the explanation
+// can show candidates that were rejected on principal
+func decideBatch(challenges []Challenge, explain bool) (decisions []Decision, err error) {
+
+	var principalIDs, resources []string
+
+	for i, c := range challenges {
+		c.Principal = normalizePrincipal(c.Principal)
+		challenges[i] = c
+
+		if !contains(principalIDs, c.Principal) {
+			principalIDs = append(principalIDs, c.Principal)
+		}
+
+		for _, pattern := range resourcePatterns(c.Object) {
+			if !contains(resources, pattern) {
+				resources = append(resources, pattern)
+			}
+		}
+	}
+
+	resolved, err := resolvePrincipals(principalIDs)
+	if err != nil {
+		return
+	}
+
+	var principals []string
+	for _, p := range resolved {
+		for _, principal := range p {
+			if !contains(principals, principal) {
+				principals = append(principals, principal)
+			}
+		}
+	}
+
+	filter := policyFilter{Resources: resources}
+	if !explain {
+		filter.Principals = principals
+	}
+
+	policies, err := listPolicies(filter)
+	if err != nil {
+		return
+	}
+
+	now := time.Now().UTC()
+	decisions = make([]Decision, len(challenges))
+
+	for i, c := range challenges {
+		matched := c.matching(resolved[c.Principal], policies)
+
+		c.Type = typeChallenge
+		c.Timestamp = now
+		c.Authorized, _ = c.evaluate(resolved[c.Principal], policies)
+		c.Policies = make([]string, len(matched))
+
+		for j, p := range matched {
+			c.Policies[j] = p.ID
+		}
+
+		challenges[i] = c
+		decisions[i] = Decision{Timestamp: now, Authorized: c.Authorized}
+
+		if explain {
+			var candidates []Policy
+			for _, p := range policies {
+				if matchResource(p.Resource, c.Object) {
+					candidates = append(candidates, p)
+				}
+			}
+
+			decisions[i].Explanation = c.explain(resolved[c.Principal], candidates)
+		}
+	}
+
+	err = recordChallenges(challenges)
+
+	return
+}
+
+func createChallengeIndex() {
+
+	ctx, cancel, client, err := connectMongo()
+	defer cancel()
+
+	if err != nil {
+		log.Printf("ChallengeInit: Failed to Connect to Mongo\t Error: %s", err.Error())
+		return
+	}
+
+	collection := client.Database(mongoDatabase).Collection(mongoCollection)
+
+	// audit records are read back by principal or by object within a time range
+	models := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{"principal", 1}, {"timestamp", -1}},
+			Options: options.Index().SetName("challenge_principal").SetPartialFilterExpression(bson.D{{"@type", typeChallenge}}),
+		},
+		{
+			Keys:    bson.D{{"object", 1}, {"timestamp", -1}},
+			Options: options.Index().SetName("challenge_object").SetPartialFilterExpression(bson.D{{"@type", typeChallenge}}),
+		},
+	}
+
+	_, err = collection.Indexes().CreateMany(ctx, models)
+
+	if err != nil {
+		log.Printf("Setting Up Challenge Index: %s", err.Error())
+	}
+
+}
+
+// recordChallenges persists the decided challenges as the audit trail
+func recordChallenges(challenges []Challenge) (err error) {
+
+	ctx, cancel, client, err := connectMongo()
+	defer cancel()
+
+	if err != nil {
+		return fmt.Errorf("%w: %s", errMongoClient, err.Error())
+	}
+
+	collection := client.Database(mongoDatabase).Collection(mongoCollection)
+
+	records := make([]interface{}, len(challenges))
+	for i := range challenges {
+		challengeID, err := uuid.NewUUID()
+		if err != nil {
+			return fmt.Errorf("%w: %s", errUUID, err.Error())
+		}
+
+		challenges[i].ID = challengeID.String()
+		records[i] = challenges[i]
+	}
+
+	_, err = collection.InsertMany(ctx, records)
+
+	return
+}
+
+// challengeFilter narrows the audit records returned by listChallenges, empty fields are ignored
+type challengeFilter struct {
+	Principals []string
+	Object     string
+	From       time.Time
+	To         time.Time
+}
+
+func (f challengeFilter) query() bson.D {
+
+	query := bson.D{{"@type", typeChallenge}}
+
+	if len(f.Principals) != 0 {
+		query = append(query, bson.E{"principal", bson.D{{"$in", f.Principals}}})
+	}
+
+	if f.Object != "" {
+		query = append(query, bson.E{"object", f.Object})
+	}
+
+	timestamp := bson.D{}
+	if !f.From.IsZero() {
+		timestamp = append(timestamp, bson.E{"$gte", f.From})
+	}
+	if !f.To.IsZero() {
+		timestamp = append(timestamp, bson.E{"$lte", f.To})
+	}
+	if len(timestamp) != 0 {
+		query = append(query, bson.E{"timestamp", timestamp})
+	}
+
+	return query
+}
+
+// listChallenges returns the matching audit records, newest first
+func listChallenges(f challengeFilter) (c []Challenge, err error) {
+
+	mongoCtx, cancel, client, err := connectMongo()
+	defer cancel()
+
+	if err != nil {
+		err = fmt.Errorf("%w: %s", errMongoClient, err.Error())
+		return
+	}
+
+	collection := client.Database(mongoDatabase).Collection(mongoCollection)
+
+	opts := options.Find().SetSort(bson.D{{"timestamp", -1}})
+	cur, err := collection.Find(mongoCtx, f.query(), opts)
+	if err != nil {
+		err = fmt.Errorf("%w: %s", errMongoQuery, err.Error())
+		return
+	}
+	defer cur.Close(mongoCtx)
+
+	err = cur.All(mongoCtx, &c)
+	if err != nil {
+		err = fmt.Errorf("%w: %s", errMongoDecode, err.Error())
+	}
+
+	return
+}
+
+// parseChallengeFilter reads the from and to query parameters as RFC3339 timestamps
+func parseChallengeFilter(r *http.Request) (f challengeFilter, err error) {
+
+	query := r.URL.Query()
+
+	if from := query.Get("from"); from != "" {
+		f.From, err = time.Parse(time.RFC3339, from)
+		if err != nil {
+			return f, fmt.Errorf("%w: from must be an RFC3339 timestamp", errModelFieldValidation)
+		}
+	}
+
+	if to := query.Get("to"); to != "" {
+		f.To, err = time.Parse(time.RFC3339, to)
+		if err != nil {
+			return f, fmt.Errorf("%w: to must be an RFC3339 timestamp", errModelFieldValidation)
+		}
+	}
+
+	return
+}
+
+// writeChallenges writes the audit records or the error fetching them as the response
+func writeChallenges(w http.ResponseWriter, challenges []Challenge, err error) {
+
+	if err != nil {
+		w.WriteHeader(500)
+		fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+		return
+	}
+
+	if challenges == nil {
+		challenges = []Challenge{}
+	}
+
+	responseBody, _ := json.Marshal(challenges)
+	w.WriteHeader(200)
+	w.Write(responseBody)
+}
+
+func contains(list []string, value string) bool {
+
+	for _, l := range list {
+		if l == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsAny(list []string, values []string) bool {
+
+	for _, l := range list {
+		for _, v := range values {
+			if l == v {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// ChallengeEvaluate is the http handler for authorization decisions
+// POST /challenge with a single challenge responds 200 when authorized and 403 otherwise
+// POST /challenge with an array of challenges responds 200 with an array of decisions in order
+// POST /challenge?explain=true adds to each decision how it was reached
+func ChallengeEvaluate(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/ld+json")
+
+	requestBody, err := ioutil.ReadAll(r.Body)
+
+	if err != nil {
+		w.WriteHeader(400)
+		fmt.Fprintf(w, `{"error": "Unable to Read Request Body"}`)
+		return
+	}
+
+	if !json.Valid(requestBody) {
+		w.WriteHeader(400)
+		fmt.Fprintf(w, `{"error": "Invalid JSON Submitted"}`)
+		return
+	}
+
+	explain, _ := strconv.ParseBool(r.URL.Query().Get("explain"))
+
+	trimmed := bytes.TrimSpace(requestBody)
+	if len(trimmed) != 0 && trimmed[0] == '[' {
+		challengeBatch(w, r, trimmed, explain)
+		return
+	}
+
+	var c Challenge
+	err = json.Unmarshal(requestBody, &c)
+
+	if err != nil {
+		w.WriteHeader(400)
+		fmt.Fprintf(w, `{"message": "Failed to Unmarshal Request JSON", "error": "%s"}`, err.Error())
+		return
+	}
+
+	err = c.validate()
+
+	if err != nil {
+		w.WriteHeader(400)
+		fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+		return
+	}
+
+	c.fromRequest(r)
+
+	d, err := c.decide(explain)
+
+	if err != nil {
+		w.WriteHeader(500)
+		fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+		return
+	}
+
+	responseBody, _ := json.Marshal(d)
+
+	if d.Authorized {
+		w.WriteHeader(200)
+	} else {
+		w.WriteHeader(403)
+	}
+
+	w.Write(responseBody)
+	return
+
+}
+
+// fromRequest records the authenticated caller, never the value in the request body,
+// and fills the request context from the http request
+func (c *Challenge) fromRequest(r *http.Request) {
+
+	c.Caller = ""
+	if caller, ok := callerFromContext(r.Context()); ok {
+		c.Caller = caller.ID
+	}
+
+	c.Context = c.Context.withDefaults(r)
+}
+
+func challengeBatch(w http.ResponseWriter, r *http.Request, requestBody []byte, explain bool) {
+
+	var challenges []Challenge
+	err := json.Unmarshal(requestBody, &challenges)
+
+	if err != nil {
+		w.WriteHeader(400)
+		fmt.Fprintf(w, `{"message": "Failed to Unmarshal Request JSON", "error": "%s"}`, err.Error())
+		return
+	}
+
+	if len(challenges) > maxBatchChallenges {
+		w.WriteHeader(400)
+		fmt.Fprintf(w, `{"error": "Batch exceeds %d Challenges"}`, maxBatchChallenges)
+		return
+	}
+
+	for i, c := range challenges {
+		if err = c.validate(); err != nil {
+			w.WriteHeader(400)
+			fmt.Fprintf(w, `{"error": "%s", "index": %d}`, err.Error(), i)
+			return
+		}
+
+		challenges[i].fromRequest(r)
+	}
+
+	decisions := []Decision{}
+
+	if len(challenges) != 0 {
+		decisions, err = decideBatch(challenges, explain)
+	}
+
+	if err != nil {
+		w.WriteHeader(500)
+		fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+		return
+	}
+
+	responseBody, _ := json.Marshal(decisions)
+	w.WriteHeader(200)
+	w.Write(responseBody)
+	return
+
+}
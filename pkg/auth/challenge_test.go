@@ -0,0 +1,200 @@
+package auth
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestChallengeEvaluate(t *testing.T) {
+
+	principals := User{ID: "orcid:1", Groups: []string{"g1"}}.principals()
+
+	allow := Policy{
+		ID:        "allow",
+		Resource:  "ark:99999/test",
+		Principal: []string{"g1"},
+		Effect:    effectAllow,
+		Action:    []string{"s3:GetObject"},
+	}
+
+	deny := Policy{
+		ID:        "deny",
+		Resource:  "ark:99999/test",
+		Principal: []string{"orcid:1"},
+		Effect:    effectDeny,
+		Action:    []string{"*"},
+	}
+
+	other := Policy{
+		ID:        "other",
+		Resource:  "ark:99999/test",
+		Principal: []string{"orcid:2"},
+		Effect:    effectAllow,
+		Action:    []string{"*"},
+	}
+
+	c := Challenge{Principal: "orcid:1", Action: "s3:GetObject", Object: "ark:99999/test"}
+
+	t.Run("AllowByGroup", func(t *testing.T) {
+		if authorized, _ := c.evaluate(principals, []Policy{allow}); !authorized {
+			t.Fatalf("Group Allow Policy did not Authorize")
+		}
+	})
+
+	t.Run("DenyOverridesAllow", func(t *testing.T) {
+		if authorized, _ := c.evaluate(principals, []Policy{allow, deny}); authorized {
+			t.Fatalf("Deny Policy did not Override Allow")
+		}
+	})
+
+	t.Run("OtherPrincipal", func(t *testing.T) {
+		if authorized, _ := c.evaluate(principals, []Policy{other}); authorized {
+			t.Fatalf("Policy for another Principal Authorized")
+		}
+	})
+
+	t.Run("NoPolicies", func(t *testing.T) {
+		if authorized, _ := c.evaluate(principals, nil); authorized {
+			t.Fatalf("Authorized without any Policy")
+		}
+	})
+
+	t.Run("NamespaceAllow", func(t *testing.T) {
+		namespace := allow
+		namespace.Resource = "ark:99999/*"
+
+		if authorized, _ := c.evaluate(principals, []Policy{namespace}); !authorized {
+			t.Fatalf("Namespace Allow Policy did not Authorize")
+		}
+	})
+
+	t.Run("NamespaceDenyOverridesExactAllow", func(t *testing.T) {
+		namespace := deny
+		namespace.Resource = "ark:99999/"
+
+		authorized, deciding := c.evaluate(principals, []Policy{allow, namespace})
+		if authorized || deciding == nil || deciding.ID != "deny" {
+			t.Fatalf("Namespace Deny Policy did not Override Allow: %+v", deciding)
+		}
+	})
+
+	t.Run("MostSpecificDeny", func(t *testing.T) {
+		namespace := deny
+		namespace.ID = "namespace"
+		namespace.Resource = "ark:99999/*"
+
+		_, deciding := c.evaluate(principals, []Policy{namespace, deny})
+		if deciding == nil || deciding.ID != "deny" {
+			t.Fatalf("Deciding Policy is not the Most Specific Deny: %+v", deciding)
+		}
+	})
+
+}
+
+func TestChallengeHandler(t *testing.T) {
+
+	t.Run("InvalidBatchItem", func(t *testing.T) {
+		requestBody := strings.NewReader(`[
+			{"principal": "orcid:1", "action": "s3:GetObject", "object": "ark:99999/test"},
+			{"principal": "orcid:1", "action": "s3:GetObject"}
+		]`)
+
+		request := httptest.NewRequest("POST", "http://localhost:8080/challenge", requestBody)
+		rr := httptest.NewRecorder()
+		ChallengeEvaluate(rr, request)
+
+		if rr.Code != 400 || !strings.Contains(rr.Body.String(), `"index": 1`) {
+			t.Fatalf("StatusCode: %d \nBody: %s", rr.Code, rr.Body.String())
+		}
+	})
+
+	t.Run("Batch", func(t *testing.T) {
+		requestBody := strings.NewReader(`[
+			{"principal": "orcid:1", "action": "s3:GetObject", "object": "ark:99999/test"},
+			{"principal": "orcid:2", "action": "s3:GetObject", "object": "ark:99999/other"}
+		]`)
+
+		request := httptest.NewRequest("POST", "http://localhost:8080/challenge", requestBody)
+		rr := httptest.NewRecorder()
+		ChallengeEvaluate(rr, request)
+
+		if rr.Code != 200 {
+			t.Fatalf("StatusCode: %d \nBody: %s", rr.Code, rr.Body.String())
+		}
+	})
+
+}
+
+func TestChallengeCaller(t *testing.T) {
+
+	request := httptest.NewRequest("POST", "http://localhost:8080/challenge", nil)
+
+	t.Run("Authenticated", func(t *testing.T) {
+		authenticated := request.WithContext(context.WithValue(request.Context(), principalKey, Principal{User: User{ID: "service"}}))
+
+		c := Challenge{Caller: "spoofed", Service: "declared"}
+		c.fromRequest(authenticated)
+
+		if c.Caller != "service" || c.Service != "declared" {
+			t.Fatalf("Caller not Recorded from Token: %+v", c)
+		}
+	})
+
+	t.Run("Unauthenticated", func(t *testing.T) {
+		c := Challenge{Caller: "spoofed"}
+		c.fromRequest(request)
+
+		if c.Caller != "" {
+			t.Fatalf("Caller taken from Request Body: %s", c.Caller)
+		}
+	})
+
+}
+
+func TestParseChallengeFilter(t *testing.T) {
+
+	request := httptest.NewRequest("GET", "http://localhost:8080/user/orcid:1/challenges?from=2020-01-01T00:00:00Z", nil)
+	filter, err := parseChallengeFilter(request)
+
+	if err != nil || filter.From.Year() != 2020 || !filter.To.IsZero() {
+		t.Fatalf("Failed to Parse Time Range: %+v %v", filter, err)
+	}
+
+	request = httptest.NewRequest("GET", "http://localhost:8080/user/orcid:1/challenges?to=yesterday", nil)
+	_, err = parseChallengeFilter(request)
+
+	if err == nil {
+		t.Fatalf("Invalid Time Range Accepted")
+	}
+
+}
+
+func TestChallengeExplain(t *testing.T) {
+
+	principals := User{ID: "orcid:1", Groups: []string{"g1"}}.principals()
+
+	allow := Policy{ID: "allow", Resource: "ark:99999/*", Principal: []string{"g1"}, Effect: effectAllow, Action: []string{"s3:*"}}
+	other := Policy{ID: "other", Resource: "ark:99999/test", Principal: []string{"orcid:2"}, Effect: effectDeny, Action: []string{"*"}}
+
+	c := Challenge{Principal: "orcid:1", Action: "s3:GetObject", Object: "ark:99999/test"}
+	e := c.explain(principals, []Policy{allow, other})
+
+	if len(e.Policies) != 2 {
+		t.Fatalf("Explanation missing Candidate Policies: %+v", e.Policies)
+	}
+
+	if !e.Policies[0].Matched {
+		t.Errorf("Allow Policy not Matched: %+v", e.Policies[0])
+	}
+
+	if e.Policies[1].Matched || e.Policies[1].Principal || !e.Policies[1].Resource || !e.Policies[1].Action {
+		t.Errorf("Other Principal Policy Evaluated Incorrectly: %+v", e.Policies[1])
+	}
+
+	if e.Deciding == nil || e.Deciding.ID != "allow" {
+		t.Errorf("Deciding Policy is not the Allow Policy: %+v", e.Deciding)
+	}
+
+}
@@ -0,0 +1,215 @@
+package auth
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Condition restricts when a policy applies, every set field must be satisfied
+// NotBefore and NotAfter bound a time window such as an embargo,
+// SourceIP lists the CIDR ranges a request must originate from,
+// and Scopes lists token scopes the caller must hold
+type Condition struct {
+	NotBefore *time.Time `json:"notBefore,omitempty" bson:"notBefore,omitempty"`
+	NotAfter  *time.Time `json:"notAfter,omitempty" bson:"notAfter,omitempty"`
+	SourceIP  []string   `json:"sourceIp,omitempty" bson:"sourceIp,omitempty"`
+	Scopes    []string   `json:"scopes,omitempty" bson:"scopes,omitempty"`
+}
+
+// RequestContext describes the request a challenge is made on behalf of
+type RequestContext struct {
+	Time     time.Time `json:"time"`
+	SourceIP string    `json:"sourceIp,omitempty"`
+	Scopes   []string  `json:"scopes,omitempty"`
+}
+
+func (c Condition) validate() error {
+
+	if c.NotBefore != nil && c.NotAfter != nil && c.NotAfter.Before(*c.NotBefore) {
+		return fmt.Errorf("%w: Policy Condition notAfter is before notBefore", errModelFieldValidation)
+	}
+
+	for _, cidr := range c.SourceIP {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("%w: Policy Condition sourceIp %s is not a CIDR range", errModelFieldValidation, cidr)
+		}
+	}
+
+	return nil
+}
+
+// satisfied reports if the request context meets every part of the condition
+func (c *Condition) satisfied(ctx RequestContext) bool {
+
+	if c == nil {
+		return true
+	}
+
+	if c.NotBefore != nil && ctx.Time.Before(*c.NotBefore) {
+		return false
+	}
+
+	if c.NotAfter != nil && ctx.Time.After(*c.NotAfter) {
+		return false
+	}
+
+	if len(c.SourceIP) != 0 && !ipInRanges(ctx.SourceIP, c.SourceIP) {
+		return false
+	}
+
+	for _, scope := range c.Scopes {
+		if !contains(ctx.Scopes, scope) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// overlaps reports if some request could satisfy both conditions,
+// their time windows intersect and their source ranges share an address.
+// Scopes never exclude each other as a token may hold all of them
+func (c *Condition) overlaps(other *Condition) bool {
+
+	if c == nil || other == nil {
+		return true
+	}
+
+	// a window starting after the other ends
+	if c.NotBefore != nil && other.NotAfter != nil && c.NotBefore.After(*other.NotAfter) {
+		return false
+	}
+
+	if other.NotBefore != nil && c.NotAfter != nil && other.NotBefore.After(*c.NotAfter) {
+		return false
+	}
+
+	if len(c.SourceIP) == 0 || len(other.SourceIP) == 0 {
+		return true
+	}
+
+	for _, a := range c.SourceIP {
+		for _, b := range other.SourceIP {
+			_, netA, errA := net.ParseCIDR(a)
+			_, netB, errB := net.ParseCIDR(b)
+
+			// aligned ranges either nest or are disjoint
+			if errA == nil && errB == nil && (netA.Contains(netB.IP) || netB.Contains(netA.IP)) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func ipInRanges(ip string, cidrs []string) bool {
+
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err == nil && ipNet.Contains(parsed) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// withDefaults fills any part of the context the caller left out from the http request
+func (ctx RequestContext) withDefaults(r *http.Request) RequestContext {
+
+	if ctx.Time.IsZero() {
+		ctx.Time = time.Now().UTC()
+	}
+
+	if ctx.SourceIP == "" {
+		ctx.SourceIP = clientIP(r)
+	}
+
+	return ctx
+}
+
+// trustedProxies are the CIDR ranges of the proxies whose X-Forwarded-For is honoured,
+// configured as a comma separated list in TRUSTED_PROXIES
+var trustedProxies []*net.IPNet
+
+func init() {
+	trustedProxies = parseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+}
+
+func parseTrustedProxies(cidrs string) (proxies []*net.IPNet) {
+
+	for _, cidr := range strings.Split(cidrs, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			log.Printf("Ignoring Trusted Proxy %s: %s", cidr, err.Error())
+			continue
+		}
+
+		proxies = append(proxies, ipNet)
+	}
+
+	return
+}
+
+func trustedProxy(ip string) bool {
+
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+
+	for _, ipNet := range trustedProxies {
+		if ipNet.Contains(parsed) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// clientIP is the address of the connection, unless it is a trusted proxy, then X-Forwarded-For
+// is read from the right skipping trusted proxies, as any address left of them may be spoofed
+func clientIP(r *http.Request) string {
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	if !trustedProxy(host) {
+		return host
+	}
+
+	forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(forwarded[i])
+		if hop == "" {
+			continue
+		}
+
+		if !trustedProxy(hop) {
+			return hop
+		}
+
+		host = hop
+	}
+
+	return host
+}
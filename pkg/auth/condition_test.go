@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestConditionSatisfied(t *testing.T) {
+
+	embargo := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	c := &Condition{
+		NotBefore: &embargo,
+		SourceIP:  []string{"128.143.0.0/16"},
+		Scopes:    []string{"download"},
+	}
+
+	ctx := RequestContext{
+		Time:     embargo.Add(time.Hour),
+		SourceIP: "128.143.10.20",
+		Scopes:   []string{"download", "openid"},
+	}
+
+	t.Run("Satisfied", func(t *testing.T) {
+		if !c.satisfied(ctx) {
+			t.Fatalf("Condition not Satisfied: %+v", ctx)
+		}
+	})
+
+	t.Run("Embargoed", func(t *testing.T) {
+		early := ctx
+		early.Time = embargo.Add(-time.Hour)
+
+		if c.satisfied(early) {
+			t.Fatalf("Condition Satisfied before Embargo Lifted")
+		}
+	})
+
+	t.Run("OffCampus", func(t *testing.T) {
+		remote := ctx
+		remote.SourceIP = "8.8.8.8"
+
+		if c.satisfied(remote) {
+			t.Fatalf("Condition Satisfied from outside Source Range")
+		}
+	})
+
+	t.Run("MissingScope", func(t *testing.T) {
+		unscoped := ctx
+		unscoped.Scopes = []string{"openid"}
+
+		if c.satisfied(unscoped) {
+			t.Fatalf("Condition Satisfied without Required Scope")
+		}
+	})
+
+	t.Run("NoCondition", func(t *testing.T) {
+		var none *Condition
+
+		if !none.satisfied(RequestContext{}) {
+			t.Fatalf("Missing Condition not Satisfied")
+		}
+	})
+
+}
+
+func TestConditionOverlaps(t *testing.T) {
+
+	epoch := time.Unix(0, 0).UTC()
+	embargo := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	before := embargo.Add(-time.Hour)
+
+	var none *Condition
+
+	for name, tc := range map[string]struct {
+		a, b     *Condition
+		overlaps bool
+	}{
+		"Unconditional":   {none, &Condition{NotBefore: &embargo}, true},
+		"TrivialWindow":   {&Condition{NotBefore: &epoch}, &Condition{NotBefore: &embargo}, true},
+		"DisjointWindows": {&Condition{NotAfter: &before}, &Condition{NotBefore: &embargo}, false},
+		"NestedRanges":    {&Condition{SourceIP: []string{"128.143.0.0/16"}}, &Condition{SourceIP: []string{"128.143.10.0/24"}}, true},
+		"DisjointRanges":  {&Condition{SourceIP: []string{"128.143.0.0/16"}}, &Condition{SourceIP: []string{"10.0.0.0/8"}}, false},
+		"RangeAndScope":   {&Condition{SourceIP: []string{"10.0.0.0/8"}}, &Condition{Scopes: []string{"download"}}, true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if tc.a.overlaps(tc.b) != tc.overlaps || tc.b.overlaps(tc.a) != tc.overlaps {
+				t.Fatalf("Conditions Overlap should be %t", tc.overlaps)
+			}
+		})
+	}
+
+}
+
+func TestConditionValidate(t *testing.T) {
+
+	if err := (Condition{SourceIP: []string{"10.0.0.0/8"}}).validate(); err != nil {
+		t.Fatalf("Valid Condition Rejected: %s", err.Error())
+	}
+
+	if err := (Condition{SourceIP: []string{"campus"}}).validate(); err == nil {
+		t.Fatalf("Condition with Invalid CIDR Accepted")
+	}
+
+}
+
+func TestRequestContextDefaults(t *testing.T) {
+
+	defer func(proxies []*net.IPNet) { trustedProxies = proxies }(trustedProxies)
+
+	// httptest requests come from 192.0.2.1
+	request := httptest.NewRequest("POST", "http://localhost:8080/challenge", nil)
+	request.Header.Set("X-Forwarded-For", "8.8.8.8, 128.143.10.20, 10.0.0.1")
+
+	t.Run("UntrustedProxy", func(t *testing.T) {
+		trustedProxies = nil
+
+		ctx := RequestContext{}.withDefaults(request)
+
+		if ctx.SourceIP != "192.0.2.1" {
+			t.Fatalf("SourceIP taken from X-Forwarded-For of an Untrusted Connection: %s", ctx.SourceIP)
+		}
+
+		if ctx.Time.IsZero() {
+			t.Fatalf("Time not Defaulted")
+		}
+	})
+
+	t.Run("TrustedProxy", func(t *testing.T) {
+		trustedProxies = parseTrustedProxies("192.0.2.0/24, 10.0.0.0/8")
+
+		ctx := RequestContext{}.withDefaults(request)
+
+		// the spoofed address left of the client is ignored
+		if ctx.SourceIP != "128.143.10.20" {
+			t.Fatalf("SourceIP not taken from X-Forwarded-For: %s", ctx.SourceIP)
+		}
+	})
+
+}
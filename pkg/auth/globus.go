@@ -15,6 +15,8 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"github.com/google/uuid"
+	"log"
+	"os"
 	"time"
 )
 
@@ -25,13 +27,24 @@ var (
 	errHTTPRequest    = errors.New("Error Preforming HTTP Request")
 )
 
+// Registration modes control what happens when someone without a user record logs in
+const (
+	// RegistrationOff refuses the login
+	RegistrationOff = "off"
+	// RegistrationAuto creates an active user and issues a session
+	RegistrationAuto = "auto"
+	// RegistrationApproval creates a pending user that an admin must approve before a session is issued
+	RegistrationApproval = "approval"
+)
+
 // GlobusAuthClient is a struct for globus credentials and provides methods and handlers for the 3-legged oauth flow
 type GlobusAuthClient struct {
-	ClientID     string
-	ClientSecret string
-	RedirectURL  string
-	LogoutURL    string
-	Scopes       string
+	ClientID         string
+	ClientSecret     string
+	RedirectURL      string
+	LogoutURL        string
+	Scopes           string
+	RegistrationMode string
 }
 
 func (g GlobusAuthClient) GrantHandler(w http.ResponseWriter, r *http.Request) {
@@ -92,49 +105,52 @@ func (g GlobusAuthClient) CodeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// TODO: (LowestPriority) Add Support for Linked accounts identities
-	//var identitiesResponse GlobusIdentitiesResponse
-	//identitiesResponse, err = g.getIdentities( introspectedToken.IdentitiesSet )
-	//if err != nil {
-	// w.Write([]byte(`{"message": "Failed to Get Identities from Introspected Globus Token", "error": "`err.Error()`"}`) )
-	//  w.WriteHeader(500)
-	// return
-	//}
-
-	// find the user in the record
-	user, err := queryUserEmail(introspectedToken.Email)
+	// find the user by any of the linked identities
+	user, err := introspectedToken.findUser()
 
-	/*
-	// if no user record is found, create a new record
-	if errors.Is(err, ErrNoDocument) {
+	// if no user record is found, register one according to the registration mode
+	registered := false
+	if err == errNoDocument && (g.RegistrationMode == RegistrationAuto || g.RegistrationMode == RegistrationApproval) {
 
-		newUser, err := introspectedToken.registerUser()
+		user, err = introspectedToken.registerUser(g.RegistrationMode == RegistrationApproval)
 
 		if err != nil {
 			response["message"] = "Failed to register new user from token"
-			response["error"] = err
-			response["globus_token"] = introspectedToken
+			response["error"] = err.Error()
+			response["status_code"] = 500
 
 			encodedResponse, _ := json.Marshal(response)
-			w.Write(encodedResponse)
 			w.WriteHeader(500)
+			w.Write(encodedResponse)
 			return
 		}
 
-		response["globus_token"] = introspectedToken
-		response["user"] = newUser
+		registered = true
+	}
 
-		encodedResponse, _ := json.Marshal(response)
-		w.Write(encodedResponse)
-		w.WriteHeader(201)
-		return
+	// record any identities linked in globus since the last login
+	if err == nil {
+		if linkErr := g.linkIdentities(&user, introspectedToken); linkErr != nil {
+			log.Printf("CodeHandler: Failed to Link Identities for %s: %s", user.ID, linkErr.Error())
+		}
 	}
-	*/
 
 	// TODO: Return Error if Login isn't found
 	// if error isn't no document found
 	if err != nil {
 
+		if errors.Is(err, errIdentityUnlinked) {
+
+			response["message"] = "Identity is Unlinked from the User"
+			response["error"] = err.Error()
+			response["status_code"] = 403
+
+			encodedResponse, _ := json.Marshal(response)
+			w.WriteHeader(403)
+			w.Write(encodedResponse)
+			return
+		}
+
 		if err == errNoDocument {
 
 			response["message"] = "No user record found"
@@ -159,6 +175,37 @@ func (g GlobusAuthClient) CodeHandler(w http.ResponseWriter, r *http.Request) {
 
 	}
 
+	// pending registrations are not issued a session until approved
+	if user.Status == statusPending {
+
+		statusCode := 403
+		if registered {
+			statusCode = 202
+		}
+
+		response["message"] = "Registration Pending Approval"
+		response["@id"] = user.ID
+		response["status_code"] = statusCode
+
+		encodedResponse, _ := json.Marshal(response)
+		w.WriteHeader(statusCode)
+		w.Write(encodedResponse)
+		return
+	}
+
+	// suspended and deleted users are refused a session
+	if !user.active() {
+
+		response["message"] = "User is " + user.Status
+		response["@id"] = user.ID
+		response["status_code"] = 403
+
+		encodedResponse, _ := json.Marshal(response)
+		w.WriteHeader(403)
+		w.Write(encodedResponse)
+		return
+	}
+
     // create a new session
     err = user.newSession()
     
@@ -177,12 +224,15 @@ func (g GlobusAuthClient) CodeHandler(w http.ResponseWriter, r *http.Request) {
 
 
 	// add a a cookie with the token value
+	// the cookie is kept from scripts and other sites, plain http is only allowed in dev mode
 	authCookie := http.Cookie{
 		Name: "fairscapeAuth",
 		Value: user.AccessToken,
 		Expires: time.Unix(int64(introspectedToken.Expiration), 0),
         Path: "/",
-        Secure: false,
+        Secure: os.Getenv("DEV_MODE") != "true",
+        HttpOnly: true,
+        SameSite: http.SameSiteStrictMode,
 	}
 
 	http.SetCookie(w, &authCookie)
@@ -252,8 +302,8 @@ func (g GlobusAuthClient) InspectHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// check if user email is in users
-	user, err = queryUserEmail(introspectedToken.Email)
+	// check if any of the token identities belong to a user
+	user, err = introspectedToken.findUser()
 
 	// if not return 404 user not found
 	if err != nil {
@@ -265,6 +315,22 @@ func (g GlobusAuthClient) InspectHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if user.Status == statusPending {
+		response["error"] = "Registration Pending Approval"
+		responseBody, _ = json.Marshal(response)
+		w.WriteHeader(403)
+		w.Write(responseBody)
+		return
+	}
+
+	if !user.active() {
+		response["error"] = "User is " + user.Status
+		responseBody, _ = json.Marshal(response)
+		w.WriteHeader(403)
+		w.Write(responseBody)
+		return
+	}
+
 
 	// else return 204 set response headers
 	w.Header().Set("X-Client-ID", user.ID)
@@ -497,17 +563,89 @@ type GlobusIntrospectedToken struct {
 	IdentitiesSet []string `json:"identities_set"`
 }
 
+// identityIDs is the primary identity followed by the rest of the identity set
+func (intro GlobusIntrospectedToken) identityIDs() (ids []string) {
+
+	if intro.Sub != "" {
+		ids = append(ids, intro.Sub)
+	}
+
+	for _, id := range intro.IdentitiesSet {
+		if !contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+
+	return
+}
+
+// findUser matches the token to a user by any linked identity, users created
+// before identities were stored are matched by email until they are linked
 func (intro GlobusIntrospectedToken) findUser() (u User, err error) {
+
+	ids := intro.identityIDs()
+
+	if len(ids) != 0 {
+		u, err = queryUserIdentity(ids)
+
+		// the remaining identities of the set still match the user after an unlink
+		if err == nil && intro.unlinkedFrom(u) {
+			return User{}, fmt.Errorf("%w: %s", errIdentityUnlinked, intro.Sub)
+		}
+
+		if err != errNoDocument {
+			return
+		}
+	}
+
 	u, err = queryUserEmail(intro.Email)
 
-	if errors.Is(err, errMongoDecode) {
-		return
+	// a user already linked to another globus account is not matched by email
+	if err == nil && u.Sub != "" {
+		u, err = User{}, errNoDocument
 	}
 
 	return
 }
 
-func (intro GlobusIntrospectedToken) registerUser() (u User, err error) {
+// unlinkedFrom reports if the primary identity of the token was unlinked from the user
+func (intro GlobusIntrospectedToken) unlinkedFrom(u User) bool {
+	return intro.Sub != "" && contains(u.Unlinked, intro.Sub)
+}
+
+// linkIdentities stores the identities of the token not yet linked to the user,
+// falling back to the bare identity ids when globus cannot describe them
+func (g GlobusAuthClient) linkIdentities(u *User, intro GlobusIntrospectedToken) error {
+
+	missing := u.missingIdentities(intro.identityIDs())
+
+	if intro.Sub == "" || (len(missing) == 0 && u.Sub != "") {
+		return nil
+	}
+
+	identities := []GlobusIdentity{}
+
+	if len(missing) != 0 {
+		resp, err := g.getIdentities(missing)
+
+		if err == nil {
+			for _, identity := range resp.Identities {
+				if contains(missing, identity.Id) {
+					identities = append(identities, identity)
+				}
+			}
+		} else {
+			for _, id := range missing {
+				identities = append(identities, GlobusIdentity{Id: id})
+			}
+		}
+	}
+
+	return u.linkIdentities(intro.Sub, identities)
+}
+
+// registerUser creates a member record from the token, pending approval if requested
+func (intro GlobusIntrospectedToken) registerUser(pending bool) (u User, err error) {
 
 	userID, err := uuid.NewUUID()
 	if err != nil {
@@ -516,8 +654,17 @@ func (intro GlobusIntrospectedToken) registerUser() (u User, err error) {
 	}
 
 	u.ID = userID.String()
+	u.Type = typeUser
 	u.Name = intro.Name
 	u.Email = intro.Email
+	u.Role = roleMember
+	u.Groups = []string{}
+	u.Status = statusActive
+	u.Sub = intro.Sub
+
+	if pending {
+		u.Status = statusPending
+	}
 
 	err = u.create()
 
@@ -529,11 +676,11 @@ type GlobusIdentitiesResponse struct {
 }
 
 type GlobusIdentity struct {
-	Username         string `json:"username"`
-	Status           string `json:"status"`
-	Name             string `json:"name"`
-	Id               string `json:"id"`
-	IdentityProvider string `json:"identity_provider"`
-	Organization     string `json:"organization"`
-	Email            string `json:"email"`
+	Username         string `json:"username" bson:"username"`
+	Status           string `json:"status" bson:"status"`
+	Name             string `json:"name" bson:"name"`
+	Id               string `json:"id" bson:"id"`
+	IdentityProvider string `json:"identity_provider" bson:"identity_provider"`
+	Organization     string `json:"organization" bson:"organization"`
+	Email            string `json:"email" bson:"email"`
 }
@@ -137,6 +137,36 @@ func listGroups() (g []Group, err error) {
 
 }
 
+// listGroupsByID returns the groups with the given ids
+func listGroupsByID(ids []string) (g []Group, err error) {
+
+	mongoCtx, cancel, client, err := connectMongo()
+	defer cancel()
+
+	if err != nil {
+		err = fmt.Errorf("%w: %s", errMongoClient, err.Error())
+		return
+	}
+
+	collection := client.Database(mongoDatabase).Collection(mongoCollection)
+
+	query := bson.D{{"@type", typeGroup}, {"@id", bson.D{{"$in", ids}}}}
+	cur, err := collection.Find(mongoCtx, query)
+
+	if err != nil {
+		err = fmt.Errorf("%w: %s", errMongoQuery, err.Error())
+		return
+	}
+	defer cur.Close(mongoCtx)
+
+	err = cur.All(mongoCtx, &g)
+	if err != nil {
+		err = fmt.Errorf("%w: %s", errMongoDecode, err.Error())
+	}
+
+	return
+}
+
 func (g *Group) get() (err error) {
 
 	ctx, cancel, client, err := connectMongo()
@@ -0,0 +1,768 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+	bson "go.mongodb.org/mongo-driver/bson"
+	options "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	errSigningKey    = errors.New("Invalid Signing Key")
+	errDefaultSecret = errors.New("Refusing to Sign Tokens with the Default Secret")
+	errUnknownKey    = errors.New("Unknown Signing Key")
+	errKeyEncryption = errors.New("Stored Signing Keys must be Encrypted")
+)
+
+const (
+	keyStatusActive  = "active"
+	keyStatusRetired = "retired"
+	keyStatusRevoked = "revoked"
+)
+
+// sealedPrefix marks stored key material encrypted with the key encryption key
+const sealedPrefix = "aes256gcm:"
+
+// keyEncryptionKey encrypts the stored signing keys, configured in JWT_KEY_ENCRYPTION_KEY
+var keyEncryptionKey []byte
+
+// keyReloadInterval limits how often an unknown kid causes the stored keys to be reloaded
+const keyReloadInterval = 10 * time.Second
+
+// defaultSecret signs tokens when nothing is configured, it is only accepted in dev mode
+const defaultSecret = "test secret"
+
+// signingKey signs session tokens and holds the key they are verified with
+type signingKey struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Private interface{}
+	Public  interface{}
+}
+
+// activeKey is the key configured from the environment, it signs new session tokens
+// until a key is rotated in and keeps verifying the tokens it signed
+var activeKey = hmacKey([]byte(defaultSecret))
+
+func hmacKey(secret []byte) signingKey {
+	return signingKey{Method: jwt.SigningMethodHS256, Private: secret, Public: secret}
+}
+
+// devMode allows the default secret and unencrypted stored keys
+func devMode() bool {
+	return os.Getenv("DEV_MODE") == "true"
+}
+
+// recordID names the key in the records retiring or revoking it, secrets have no kid
+// so they are named by an HMAC of a fixed message, which reveals no more than any token they sign
+func (k signingKey) recordID() string {
+
+	if k.ID != "" {
+		return k.ID
+	}
+
+	secret, _ := k.Private.([]byte)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("fairscape signing key"))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// parseSigningKey reads an RSA private key for RS256 or a P-256 private key for ES256 from PEM
+func parseSigningKey(pemBytes []byte) (k signingKey, err error) {
+
+	if rsaKey, err := jwt.ParseRSAPrivateKeyFromPEM(pemBytes); err == nil {
+		k = signingKey{Method: jwt.SigningMethodRS256, Private: rsaKey, Public: &rsaKey.PublicKey}
+		k.ID, err = k.thumbprint()
+		return k, err
+	}
+
+	ecKey, err := parseECPrivateKey(pemBytes)
+	if err != nil {
+		return k, fmt.Errorf("%w: PEM is not an RSA or EC Private Key", errSigningKey)
+	}
+
+	if ecKey.Curve.Params().Name != elliptic.P256().Params().Name {
+		return k, fmt.Errorf("%w: EC Keys must use the P-256 Curve for ES256", errSigningKey)
+	}
+
+	k = signingKey{Method: jwt.SigningMethodES256, Private: ecKey, Public: &ecKey.PublicKey}
+	k.ID, err = k.thumbprint()
+	return
+}
+
+// parseECPrivateKey accepts SEC1 and PKCS8 encoded EC keys, jwt-go only parses SEC1
+func parseECPrivateKey(pemBytes []byte) (*ecdsa.PrivateKey, error) {
+
+	if ecKey, err := jwt.ParseECPrivateKeyFromPEM(pemBytes); err == nil {
+		return ecKey, nil
+	}
+
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, jwt.ErrKeyMustBePEMEncoded
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	ecKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, jwt.ErrNotECPrivateKey
+	}
+
+	return ecKey, nil
+}
+
+// LoadSigningKey configures how session tokens are signed from the environment.
+// JWT_SIGNING_KEY is the path of a PEM private key for RS256 or ES256, otherwise
+// tokens are signed HS256 with JWT_SECRET. Without either the default secret is
+// only used when DEV_MODE is true. JWT_KEY_ENCRYPTION_KEY is a base64 256 bit key
+// encrypting the keys made by rotation
+func LoadSigningKey() error {
+
+	keyEncryptionKey = nil
+	if encoded := os.Getenv("JWT_KEY_ENCRYPTION_KEY"); encoded != "" {
+
+		kek, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(kek) != 32 {
+			return fmt.Errorf("%w: JWT_KEY_ENCRYPTION_KEY must be 32 bytes of base64", errSigningKey)
+		}
+
+		keyEncryptionKey = kek
+	}
+
+	if path := os.Getenv("JWT_SIGNING_KEY"); path != "" {
+
+		pemBytes, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("%w: Failed to Read %s: %s", errSigningKey, path, err.Error())
+		}
+
+		k, err := parseSigningKey(pemBytes)
+		if err != nil {
+			return err
+		}
+
+		activeKey = k
+		return nil
+	}
+
+	secret := os.Getenv("JWT_SECRET")
+
+	if secret == "" || secret == defaultSecret {
+		if !devMode() {
+			return fmt.Errorf("%w: set JWT_SIGNING_KEY or JWT_SECRET, or DEV_MODE=true", errDefaultSecret)
+		}
+
+		secret = defaultSecret
+	}
+
+	activeKey = hmacKey([]byte(secret))
+	return nil
+}
+
+// sign creates a token for the claims signed with the key, naming the key in the kid header
+func (k signingKey) sign(claims jwt.Claims) (string, error) {
+
+	token := jwt.NewWithClaims(k.Method, claims)
+
+	if k.ID != "" {
+		token.Header["kid"] = k.ID
+	}
+
+	return token.SignedString(k.Private)
+}
+
+// keyFunc returns the verification key of a token, refusing any algorithm other than the key's
+// so a token cannot choose how it is verified
+func (k signingKey) keyFunc(token *jwt.Token) (interface{}, error) {
+
+	if token.Method.Alg() != k.Method.Alg() {
+		return nil, fmt.Errorf("Unexpected Signing Method %v", token.Header["alg"])
+	}
+
+	return k.Public, nil
+}
+
+// jwk is the public JSON Web Key of an asymmetric key, secrets are never published
+func (k signingKey) jwk() (map[string]string, bool) {
+
+	encode := func(b []byte) string {
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+
+	switch public := k.Public.(type) {
+
+	case *rsa.PublicKey:
+		return map[string]string{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": k.Method.Alg(),
+			"kid": k.ID,
+			"n":   encode(public.N.Bytes()),
+			"e":   encode(big.NewInt(int64(public.E)).Bytes()),
+		}, true
+
+	case *ecdsa.PublicKey:
+		size := (public.Curve.Params().BitSize + 7) / 8
+		return map[string]string{
+			"kty": "EC",
+			"use": "sig",
+			"alg": k.Method.Alg(),
+			"kid": k.ID,
+			"crv": public.Curve.Params().Name,
+			"x":   encode(padBytes(public.X.Bytes(), size)),
+			"y":   encode(padBytes(public.Y.Bytes(), size)),
+		}, true
+	}
+
+	return nil, false
+}
+
+// thumbprint is the RFC 7638 thumbprint of the public key, used as its key id
+func (k signingKey) thumbprint() (string, error) {
+
+	jwk, ok := k.jwk()
+	if !ok {
+		return "", fmt.Errorf("%w: Only Asymmetric Keys have a Thumbprint", errSigningKey)
+	}
+
+	// the required members in lexicographic order, json.Marshal sorts map keys
+	required := map[string]string{"kty": jwk["kty"]}
+	members := []string{"e", "n"}
+	if jwk["kty"] == "EC" {
+		members = []string{"crv", "x", "y"}
+	}
+
+	for _, m := range members {
+		required[m] = jwk[m]
+	}
+
+	encoded, err := json.Marshal(required)
+	if err != nil {
+		return "", err
+	}
+
+	digest := sha256.Sum256(encoded)
+	return base64.RawURLEncoding.EncodeToString(digest[:]), nil
+}
+
+func padBytes(b []byte, size int) []byte {
+
+	if len(b) >= size {
+		return b
+	}
+
+	return append(make([]byte, size-len(b)), b...)
+}
+
+// JWKSHandler publishes the public keys session tokens are verified with
+// GET /.well-known/jwks.json
+func JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	keys := []map[string]string{}
+
+	for _, k := range verificationKeys() {
+		if jwk, ok := k.jwk(); ok {
+			keys = append(keys, jwk)
+		}
+	}
+
+	responseBody, _ := json.Marshal(map[string]interface{}{"keys": keys})
+	w.WriteHeader(200)
+	w.Write(responseBody)
+	return
+}
+
+// StoredKey is a rotated signing key shared by every instance through mongo.
+// The active key signs new sessions, retired keys verify until ExpiresAt.
+// The key configured from the environment is recorded without its material once it is
+// retired or revoked, those records are kept so it is never trusted again.
+// Key is encrypted with the key encryption key, only dev mode stores it in plaintext
+type StoredKey struct {
+	ID        string     `json:"@id" bson:"@id"`
+	Type      string     `json:"@type" bson:"@type"`
+	Algorithm string     `json:"alg" bson:"alg"`
+	Status    string     `json:"status" bson:"status"`
+	Created   time.Time  `json:"created" bson:"created"`
+	RetiredAt *time.Time `json:"retiredAt,omitempty" bson:"retiredAt,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty" bson:"expiresAt,omitempty"`
+	Key       string     `json:"-" bson:"key"`
+}
+
+// keyRing holds the stored keys loaded from mongo and the record of the configured key
+var keyRing = struct {
+	sync.RWMutex
+	active     *signingKey
+	keys       map[string]signingKey
+	configured *StoredKey
+	loaded     time.Time
+}{keys: map[string]signingKey{}}
+
+// configuredVerifies reports if the configured key has not been retired past its expiry or revoked,
+// the key ring must be locked
+func configuredVerifies() bool {
+
+	c := keyRing.configured
+	return c == nil || c.ExpiresAt == nil || time.Now().Before(*c.ExpiresAt)
+}
+
+// sessionKey is the stored active key, or the configured key before any rotation
+func sessionKey() signingKey {
+
+	keyRing.RLock()
+	defer keyRing.RUnlock()
+
+	if keyRing.active != nil {
+		return *keyRing.active
+	}
+
+	return activeKey
+}
+
+// verificationKeys are the configured key while it verifies and every stored key that has not expired
+func verificationKeys() []signingKey {
+
+	keyRing.RLock()
+	defer keyRing.RUnlock()
+
+	keys := []signingKey{}
+	if configuredVerifies() {
+		keys = append(keys, activeKey)
+	}
+
+	for _, k := range keyRing.keys {
+		if k.ID != activeKey.ID {
+			keys = append(keys, k)
+		}
+	}
+
+	return keys
+}
+
+func lookupKey(kid string) (signingKey, bool) {
+
+	keyRing.RLock()
+	defer keyRing.RUnlock()
+
+	if kid == activeKey.ID {
+		return activeKey, configuredVerifies()
+	}
+
+	k, ok := keyRing.keys[kid]
+	return k, ok
+}
+
+// verificationKeyFunc verifies a token with the key named by its kid, reloading the stored keys
+// when the kid is unknown as another instance may have rotated
+func verificationKeyFunc(token *jwt.Token) (interface{}, error) {
+
+	kid, _ := token.Header["kid"].(string)
+
+	k, ok := lookupKey(kid)
+
+	if !ok {
+		keyRing.RLock()
+		stale := time.Since(keyRing.loaded) > keyReloadInterval
+		keyRing.RUnlock()
+
+		if stale {
+			if err := LoadStoredKeys(); err != nil {
+				log.Printf("Failed to Reload Signing Keys: %s", err.Error())
+			}
+			k, ok = lookupKey(kid)
+		}
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", errUnknownKey, kid)
+	}
+
+	return k.keyFunc(token)
+}
+
+// generateSigningKey creates a key for the algorithm and the material to store it as,
+// PKCS8 PEM for RS256 and ES256 or a base64 secret for HS256
+func generateSigningKey(alg string) (k signingKey, material string, err error) {
+
+	switch alg {
+
+	case jwt.SigningMethodRS256.Alg():
+		rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return k, "", err
+		}
+		k = signingKey{Method: jwt.SigningMethodRS256, Private: rsaKey, Public: &rsaKey.PublicKey}
+		material, err = pemPKCS8(rsaKey)
+		if err != nil {
+			return k, "", err
+		}
+
+	case jwt.SigningMethodES256.Alg():
+		ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return k, "", err
+		}
+		k = signingKey{Method: jwt.SigningMethodES256, Private: ecKey, Public: &ecKey.PublicKey}
+		material, err = pemPKCS8(ecKey)
+		if err != nil {
+			return k, "", err
+		}
+
+	case jwt.SigningMethodHS256.Alg():
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return k, "", err
+		}
+
+		keyID, err := uuid.NewRandom()
+		if err != nil {
+			return k, "", fmt.Errorf("%w: %s", errUUID, err.Error())
+		}
+
+		k = hmacKey(secret)
+		k.ID = keyID.String()
+		return k, base64.StdEncoding.EncodeToString(secret), nil
+
+	default:
+		return k, "", fmt.Errorf("%w: Unsupported Algorithm %s", errSigningKey, alg)
+	}
+
+	k.ID, err = k.thumbprint()
+	return
+}
+
+func pemPKCS8(key interface{}) (string, error) {
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), nil
+}
+
+// sealKey encrypts key material for storage with AES-256-GCM, the key id is authenticated
+// so records cannot be swapped. Without a key encryption key only dev mode stores plaintext
+func sealKey(id string, material string) (string, error) {
+
+	if keyEncryptionKey == nil {
+		if devMode() {
+			return material, nil
+		}
+		return "", fmt.Errorf("%w: set JWT_KEY_ENCRYPTION_KEY", errKeyEncryption)
+	}
+
+	aead, err := keyCipher()
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, []byte(material), []byte(id))
+	return sealedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// openKey decrypts the stored key material
+func (s StoredKey) openKey() (string, error) {
+
+	if !strings.HasPrefix(s.Key, sealedPrefix) {
+		if devMode() {
+			return s.Key, nil
+		}
+		return "", fmt.Errorf("%w: Stored Key %s is Plaintext", errKeyEncryption, s.ID)
+	}
+
+	if keyEncryptionKey == nil {
+		return "", fmt.Errorf("%w: set JWT_KEY_ENCRYPTION_KEY to read Stored Key %s", errKeyEncryption, s.ID)
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(s.Key, sealedPrefix))
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", errSigningKey, err.Error())
+	}
+
+	aead, err := keyCipher()
+	if err != nil {
+		return "", err
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("%w: Stored Key %s is Truncated", errSigningKey, s.ID)
+	}
+
+	material, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(s.ID))
+	if err != nil {
+		return "", fmt.Errorf("%w: Failed to Decrypt Stored Key %s", errSigningKey, s.ID)
+	}
+
+	return string(material), nil
+}
+
+func keyCipher() (cipher.AEAD, error) {
+
+	block, err := aes.NewCipher(keyEncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// signingKey decrypts and parses the stored key material
+func (s StoredKey) signingKey() (k signingKey, err error) {
+
+	material, err := s.openKey()
+	if err != nil {
+		return
+	}
+
+	if s.Algorithm == jwt.SigningMethodHS256.Alg() {
+		secret, err := base64.StdEncoding.DecodeString(material)
+		if err != nil {
+			return k, fmt.Errorf("%w: %s", errSigningKey, err.Error())
+		}
+		k = hmacKey(secret)
+	} else {
+		k, err = parseSigningKey([]byte(material))
+		if err != nil {
+			return
+		}
+	}
+
+	if k.Method.Alg() != s.Algorithm {
+		return k, fmt.Errorf("%w: Stored Key %s is not %s", errSigningKey, s.ID, s.Algorithm)
+	}
+
+	k.ID = s.ID
+	return
+}
+
+// LoadStoredKeys replaces the key ring with the stored keys that have not expired
+// and the record retiring or revoking the configured key. Until it first succeeds the
+// configured key is trusted, so a server must not start when it fails
+func LoadStoredKeys() error {
+
+	ctx, cancel, client, err := connectMongo()
+	defer cancel()
+
+	if err != nil {
+		return fmt.Errorf("%w: %s", errMongoClient, err.Error())
+	}
+
+	collection := client.Database(mongoDatabase).Collection(mongoCollection)
+
+	// the newest active key wins should a rotation be interrupted
+	opts := options.Find().SetSort(bson.D{{"created", 1}})
+	cur, err := collection.Find(ctx, bson.D{
+		{"@type", typeSigningKey},
+		{"$or", bson.A{
+			bson.D{{"status", keyStatusActive}},
+			bson.D{{"expiresAt", bson.D{{"$gt", time.Now().UTC()}}}},
+			bson.D{{"@id", activeKey.recordID()}},
+		}},
+	}, opts)
+
+	if err != nil {
+		return fmt.Errorf("%w: %s", errMongoQuery, err.Error())
+	}
+	defer cur.Close(ctx)
+
+	var stored []StoredKey
+	if err = cur.All(ctx, &stored); err != nil {
+		return fmt.Errorf("%w: %s", errMongoDecode, err.Error())
+	}
+
+	keys := make(map[string]signingKey, len(stored))
+	var active *signingKey
+	var configured *StoredKey
+
+	for i, s := range stored {
+
+		if s.ID == activeKey.recordID() {
+			configured = &stored[i]
+			continue
+		}
+
+		k, err := s.signingKey()
+		if err != nil {
+			return err
+		}
+
+		keys[k.ID] = k
+
+		if s.Status == keyStatusActive {
+			active = &k
+		}
+	}
+
+	keyRing.Lock()
+	keyRing.keys = keys
+	keyRing.active = active
+	keyRing.configured = configured
+	keyRing.loaded = time.Now()
+	keyRing.Unlock()
+
+	return nil
+}
+
+// RefreshStoredKeys reloads the stored keys on an interval so rotations on other instances are picked up
+func RefreshStoredKeys(interval time.Duration) {
+
+	for range time.Tick(interval) {
+		if err := LoadStoredKeys(); err != nil {
+			log.Printf("Failed to Refresh Signing Keys: %s", err.Error())
+		}
+	}
+}
+
+// RotateSigningKey makes a new key of the same algorithm the active key signs with.
+// Earlier keys, including the key configured from the environment, are retired and verify
+// the sessions they signed until those expire. When revoke is set for a leaked key every
+// earlier key is revoked instead and their sessions end immediately.
+// Retired keys that have expired are removed, the records of the configured key are kept
+func RotateSigningKey(revoke bool) (s StoredKey, err error) {
+
+	previous := sessionKey()
+
+	k, material, err := generateSigningKey(previous.Method.Alg())
+	if err != nil {
+		return
+	}
+
+	sealed, err := sealKey(k.ID, material)
+	if err != nil {
+		return
+	}
+
+	now := time.Now().UTC()
+	s = StoredKey{
+		ID:        k.ID,
+		Type:      typeSigningKey,
+		Algorithm: k.Method.Alg(),
+		Status:    keyStatusActive,
+		Created:   now,
+		Key:       sealed,
+	}
+
+	ctx, cancel, client, err := connectMongo()
+	defer cancel()
+
+	if err != nil {
+		return s, fmt.Errorf("%w: %s", errMongoClient, err.Error())
+	}
+
+	collection := client.Database(mongoDatabase).Collection(mongoCollection)
+
+	if _, err = collection.InsertOne(ctx, s); err != nil {
+		return s, fmt.Errorf("%w: %s", errMongoQuery, err.Error())
+	}
+
+	// records of configured keys hold no material
+	stored := bson.D{{"$gt", ""}}
+	configured := bson.D{{"@id", activeKey.recordID()}, {"@type", typeSigningKey}}
+	upsert := options.Update().SetUpsert(true)
+
+	if revoke {
+		_, err = collection.DeleteMany(ctx, bson.D{{"@type", typeSigningKey}, {"@id", bson.D{{"$ne", s.ID}}}, {"key", stored}})
+
+		if err == nil {
+			_, err = collection.UpdateOne(ctx, configured, bson.D{
+				{"$set", bson.D{{"status", keyStatusRevoked}, {"retiredAt", now}, {"expiresAt", now}}},
+				{"$setOnInsert", bson.D{{"alg", activeKey.Method.Alg()}, {"created", now}, {"key", ""}}},
+			}, upsert)
+		}
+	} else {
+		expires := now.Add(sessionLifetime)
+		_, err = collection.UpdateMany(ctx,
+			bson.D{{"@type", typeSigningKey}, {"status", keyStatusActive}, {"@id", bson.D{{"$ne", s.ID}}}},
+			bson.D{{"$set", bson.D{
+				{"status", keyStatusRetired},
+				{"retiredAt", now},
+				{"expiresAt", expires},
+			}}},
+		)
+
+		// the configured key is retired once, later rotations leave its record unchanged
+		if err == nil {
+			_, err = collection.UpdateOne(ctx, configured, bson.D{
+				{"$setOnInsert", bson.D{
+					{"alg", activeKey.Method.Alg()},
+					{"status", keyStatusRetired},
+					{"created", now},
+					{"retiredAt", now},
+					{"expiresAt", expires},
+					{"key", ""},
+				}},
+			}, upsert)
+		}
+	}
+
+	if err != nil {
+		return s, fmt.Errorf("%w: %s", errMongoQuery, err.Error())
+	}
+
+	_, err = collection.DeleteMany(ctx, bson.D{
+		{"@type", typeSigningKey},
+		{"status", keyStatusRetired},
+		{"expiresAt", bson.D{{"$lte", now}}},
+		{"key", stored},
+	})
+
+	if err != nil {
+		return s, fmt.Errorf("%w: %s", errMongoQuery, err.Error())
+	}
+
+	err = LoadStoredKeys()
+	return
+}
+
+// KeyRotateHandler rotates the signing key, ?revoke=true ends the sessions of every earlier key
+// POST /keys/rotate
+func KeyRotateHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/ld+json")
+
+	s, err := RotateSigningKey(r.URL.Query().Get("revoke") == "true")
+
+	if err != nil {
+		w.WriteHeader(500)
+		fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+		return
+	}
+
+	responseBody, _ := json.Marshal(s)
+	w.WriteHeader(201)
+	w.Write(responseBody)
+	return
+}
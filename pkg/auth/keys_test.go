@@ -0,0 +1,298 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func TestSigningKeys(t *testing.T) {
+
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	rsaPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)})
+
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ecDER, _ := x509.MarshalECPrivateKey(ecKey)
+	ecPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDER})
+
+	defaultKey := activeKey
+	defer func() { activeKey = defaultKey }()
+
+	for alg, pemBytes := range map[string][]byte{"RS256": rsaPEM, "ES256": ecPEM} {
+		t.Run(alg, func(t *testing.T) {
+
+			k, err := parseSigningKey(pemBytes)
+			if err != nil {
+				t.Fatalf("Failed Parsing Key: %s", err.Error())
+			}
+
+			if k.Method.Alg() != alg || k.ID == "" {
+				t.Fatalf("Key Parsed Incorrectly: %s %s", k.Method.Alg(), k.ID)
+			}
+
+			activeKey = k
+			u := User{ID: "member", Role: roleMember}
+
+			if err := u.newSession(); err != nil {
+				t.Fatalf("Failed Signing Session: %s", err.Error())
+			}
+
+			if _, err := verifyToken(u.AccessToken); err != nil {
+				t.Fatalf("Signed Token Rejected: %s", err.Error())
+			}
+
+			rr := httptest.NewRecorder()
+			JWKSHandler(rr, httptest.NewRequest("GET", "http://localhost:8080/.well-known/jwks.json", nil))
+
+			var jwks struct {
+				Keys []map[string]string `json:"keys"`
+			}
+			json.Unmarshal(rr.Body.Bytes(), &jwks)
+
+			if len(jwks.Keys) != 1 || jwks.Keys[0]["kid"] != k.ID || jwks.Keys[0]["alg"] != alg {
+				t.Errorf("JWKS Incorrect: %s", rr.Body.String())
+			}
+		})
+	}
+
+	t.Run("AlgorithmConfusion", func(t *testing.T) {
+		k, _ := parseSigningKey(rsaPEM)
+		activeKey = k
+
+		// an HS256 token keyed with the public key must not verify
+		forged, _ := hmacKey(x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey)).sign(UserTokenClaims{})
+		if _, err := verifyToken(forged); err == nil {
+			t.Errorf("HS256 Token Accepted by RS256 Key")
+		}
+	})
+
+	t.Run("UnsupportedCurve", func(t *testing.T) {
+		p384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+		der, _ := x509.MarshalECPrivateKey(p384)
+
+		if _, err := parseSigningKey(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})); err == nil {
+			t.Errorf("P-384 Key Accepted for ES256")
+		}
+	})
+
+	t.Run("SecretsNotPublished", func(t *testing.T) {
+		activeKey = hmacKey([]byte("secret"))
+
+		rr := httptest.NewRecorder()
+		JWKSHandler(rr, httptest.NewRequest("GET", "http://localhost:8080/.well-known/jwks.json", nil))
+
+		if rr.Body.String() != `{"keys":[]}` {
+			t.Errorf("JWKS Published a Secret: %s", rr.Body.String())
+		}
+	})
+
+	t.Run("DefaultSecret", func(t *testing.T) {
+		os.Unsetenv("JWT_SIGNING_KEY")
+		os.Unsetenv("JWT_SECRET")
+		os.Unsetenv("DEV_MODE")
+
+		if err := LoadSigningKey(); err == nil {
+			t.Errorf("Default Secret Accepted Outside Dev Mode")
+		}
+
+		os.Setenv("DEV_MODE", "true")
+		defer os.Unsetenv("DEV_MODE")
+
+		if err := LoadSigningKey(); err != nil {
+			t.Errorf("Default Secret Refused in Dev Mode: %s", err.Error())
+		}
+	})
+
+}
+
+func TestKeyRotation(t *testing.T) {
+
+	defaultKey := activeKey
+	defer func() {
+		activeKey = defaultKey
+		keyEncryptionKey = nil
+		keyRing.keys = map[string]signingKey{}
+		keyRing.active = nil
+		keyRing.configured = nil
+	}()
+
+	os.Unsetenv("DEV_MODE")
+	keyEncryptionKey = make([]byte, 32)
+	rand.Read(keyEncryptionKey)
+
+	stored := map[string]signingKey{}
+
+	for _, alg := range []string{"RS256", "ES256", "HS256"} {
+		t.Run(alg, func(t *testing.T) {
+
+			k, material, err := generateSigningKey(alg)
+			if err != nil {
+				t.Fatalf("Failed Generating Key: %s", err.Error())
+			}
+
+			sealed, err := sealKey(k.ID, material)
+			if err != nil {
+				t.Fatalf("Failed Sealing Key: %s", err.Error())
+			}
+
+			parsed, err := StoredKey{ID: k.ID, Algorithm: alg, Key: sealed}.signingKey()
+			if err != nil {
+				t.Fatalf("Failed Parsing Stored Key: %s", err.Error())
+			}
+
+			if parsed.ID != k.ID || parsed.Method.Alg() != alg {
+				t.Errorf("Stored Key Parsed Incorrectly: %s %s", parsed.Method.Alg(), parsed.ID)
+			}
+
+			stored[alg] = parsed
+		})
+	}
+
+	if _, _, err := generateSigningKey("none"); err == nil {
+		t.Errorf("Unsupported Algorithm Accepted")
+	}
+
+	activeKey = hmacKey([]byte("configured"))
+	retired, current := stored["ES256"], stored["RS256"]
+
+	keyRing.keys = map[string]signingKey{retired.ID: retired, current.ID: current}
+	keyRing.active = &current
+	keyRing.loaded = time.Now()
+
+	t.Run("SignsWithActiveKey", func(t *testing.T) {
+		u := User{ID: "member", Role: roleMember}
+		u.newSession()
+
+		token, _ := jwt.Parse(u.AccessToken, nil)
+		if token == nil || token.Header["kid"] != current.ID {
+			t.Fatalf("Session Not Signed by the Active Key")
+		}
+
+		if _, err := verifyToken(u.AccessToken); err != nil {
+			t.Errorf("Active Key Token Rejected: %s", err.Error())
+		}
+	})
+
+	t.Run("RetiredKeyVerifies", func(t *testing.T) {
+		tokenString, _ := retired.sign(UserTokenClaims{StandardClaims: jwt.StandardClaims{
+			Subject:   "member",
+			Issuer:    tokenIssuer,
+			Audience:  tokenAudience,
+			ExpiresAt: time.Now().Add(time.Hour).Unix(),
+		}})
+
+		if _, err := verifyToken(tokenString); err != nil {
+			t.Errorf("Retired Key Token Rejected: %s", err.Error())
+		}
+	})
+
+	t.Run("UnknownKeyRejected", func(t *testing.T) {
+		tokenString, _ := stored["HS256"].sign(UserTokenClaims{StandardClaims: jwt.StandardClaims{
+			Subject:   "member",
+			Issuer:    tokenIssuer,
+			Audience:  tokenAudience,
+			ExpiresAt: time.Now().Add(time.Hour).Unix(),
+		}})
+
+		if _, err := verifyToken(tokenString); err == nil {
+			t.Errorf("Token Signed by an Unknown Key Accepted")
+		}
+	})
+
+	t.Run("SealedKeys", func(t *testing.T) {
+		k, material, _ := generateSigningKey("ES256")
+		sealed, _ := sealKey(k.ID, material)
+
+		if strings.Contains(sealed, "PRIVATE KEY") {
+			t.Fatalf("Stored Key not Encrypted")
+		}
+
+		if _, err := (StoredKey{ID: "swapped", Algorithm: "ES256", Key: sealed}).signingKey(); err == nil {
+			t.Errorf("Sealed Key Opened under Another ID")
+		}
+
+		if _, err := (StoredKey{ID: k.ID, Algorithm: "ES256", Key: material}).signingKey(); err == nil {
+			t.Errorf("Plaintext Stored Key Accepted outside Dev Mode")
+		}
+
+		kek := keyEncryptionKey
+		keyEncryptionKey = nil
+		defer func() { keyEncryptionKey = kek }()
+
+		if _, err := sealKey(k.ID, material); err == nil {
+			t.Errorf("Key Stored without Encryption outside Dev Mode")
+		}
+	})
+
+	t.Run("ConfiguredKeyRecord", func(t *testing.T) {
+		other := hmacKey([]byte("other"))
+
+		if id := activeKey.recordID(); id == "" || id == other.recordID() {
+			t.Fatalf("Configured Key Record ID Incorrect: %s", id)
+		}
+
+		if current.recordID() != current.ID {
+			t.Errorf("Asymmetric Key not Recorded by its kid")
+		}
+	})
+
+	t.Run("ConfiguredKeyRetiredAndRevoked", func(t *testing.T) {
+		defer func() { keyRing.configured = nil }()
+
+		tokenString, _ := activeKey.sign(UserTokenClaims{StandardClaims: jwt.StandardClaims{
+			Subject:   "member",
+			Issuer:    tokenIssuer,
+			Audience:  tokenAudience,
+			ExpiresAt: time.Now().Add(time.Hour).Unix(),
+		}})
+
+		if _, err := verifyToken(tokenString); err != nil {
+			t.Fatalf("Configured Key Token Rejected: %s", err.Error())
+		}
+
+		expires := time.Now().Add(time.Hour)
+		keyRing.configured = &StoredKey{ID: activeKey.recordID(), Status: keyStatusRetired, ExpiresAt: &expires}
+
+		if _, err := verifyToken(tokenString); err != nil {
+			t.Errorf("Retired Configured Key Token Rejected before Expiry: %s", err.Error())
+		}
+
+		revoked := time.Now().Add(-time.Second)
+		keyRing.configured = &StoredKey{ID: activeKey.recordID(), Status: keyStatusRevoked, ExpiresAt: &revoked}
+
+		if _, err := verifyToken(tokenString); err == nil {
+			t.Errorf("Revoked Configured Key Token Accepted")
+		}
+	})
+
+	t.Run("JWKSListsRingKeys", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		JWKSHandler(rr, httptest.NewRequest("GET", "http://localhost:8080/.well-known/jwks.json", nil))
+
+		var jwks struct {
+			Keys []map[string]string `json:"keys"`
+		}
+		json.Unmarshal(rr.Body.Bytes(), &jwks)
+
+		kids := []string{}
+		for _, k := range jwks.Keys {
+			kids = append(kids, k["kid"])
+		}
+
+		if len(kids) != 2 || !contains(kids, current.ID) || !contains(kids, retired.ID) {
+			t.Errorf("JWKS Incorrect: %s", rr.Body.String())
+		}
+	})
+
+}
@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Actions are namespaced by service, e.g. s3:GetObject or mds:CreateIdentifier
+// a policy may name an action exactly or with a trailing wildcard
+//   *            every action of every service
+//   s3:*         every action of the s3 service
+//   s3:Get*      every s3 action beginning with Get
+// matching is case insensitive as service clients are inconsistent about case
+
+// validActionPattern checks that a wildcard only appears at the end of the pattern
+func validActionPattern(pattern string) error {
+
+	if pattern == "" {
+		return fmt.Errorf("%w: Policy Action is empty", errModelFieldValidation)
+	}
+
+	if i := strings.Index(pattern, "*"); i != -1 && i != len(pattern)-1 {
+		return fmt.Errorf("%w: Policy Action %s may only end with a wildcard", errModelFieldValidation, pattern)
+	}
+
+	return nil
+}
+
+// matchAction reports if the action is granted by the pattern
+func matchAction(pattern string, action string) bool {
+
+	pattern = strings.ToLower(pattern)
+	action = strings.ToLower(action)
+
+	if strings.HasSuffix(pattern, "*") {
+		return strings.HasPrefix(action, strings.TrimSuffix(pattern, "*"))
+	}
+
+	return pattern == action
+}
+
+// matchAnyAction reports if any of the patterns grants the action
+func matchAnyAction(patterns []string, action string) bool {
+
+	for _, pattern := range patterns {
+		if matchAction(pattern, action) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// actionPatternsOverlap reports if some action would be matched by both patterns
+func actionPatternsOverlap(a string, b string) bool {
+
+	a = strings.ToLower(a)
+	b = strings.ToLower(b)
+
+	aPrefix, aGlob := strings.TrimSuffix(a, "*"), strings.HasSuffix(a, "*")
+	bPrefix, bGlob := strings.TrimSuffix(b, "*"), strings.HasSuffix(b, "*")
+
+	switch {
+	case aGlob && bGlob:
+		return strings.HasPrefix(aPrefix, bPrefix) || strings.HasPrefix(bPrefix, aPrefix)
+	case aGlob:
+		return strings.HasPrefix(b, aPrefix)
+	case bGlob:
+		return strings.HasPrefix(a, bPrefix)
+	}
+
+	return a == b
+}
+
+// actionsOverlap reports if any pattern in one list overlaps a pattern in the other
+func actionsOverlap(a []string, b []string) bool {
+
+	for _, x := range a {
+		for _, y := range b {
+			if actionPatternsOverlap(x, y) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Resources are identifiers such as ark:99999/project-a/dataset, a policy on a
+// namespace applies to every identifier beneath it
+//   ark:99999/project-a/dataset   only that identifier
+//   ark:99999/project-a/          every identifier under project-a
+//   ark:99999/*                   every identifier under ark:99999
+
+// validResourcePattern checks that a wildcard only follows the final separator
+func validResourcePattern(pattern string) error {
+
+	if i := strings.Index(pattern, "*"); i != -1 && (i != len(pattern)-1 || !strings.HasSuffix(pattern, "/*")) {
+		return fmt.Errorf("%w: Policy Resource %s may only end with /*", errModelFieldValidation, pattern)
+	}
+
+	return nil
+}
+
+// isNamespacePattern reports if the pattern names a namespace rather than a single identifier
+func isNamespacePattern(pattern string) bool {
+	return strings.HasSuffix(pattern, "/") || strings.HasSuffix(pattern, "/*")
+}
+
+// matchResource reports if the resource pattern applies to the identifier
+func matchResource(pattern string, id string) bool {
+
+	if pattern == id {
+		return true
+	}
+
+	if isNamespacePattern(pattern) {
+		return strings.HasPrefix(id, strings.TrimSuffix(pattern, "*"))
+	}
+
+	return false
+}
+
+// resourcesOverlap reports if two resource patterns apply to a common identifier,
+// either they are equal or one is a namespace containing the other
+func resourcesOverlap(a string, b string) bool {
+	return matchResource(a, b) || matchResource(b, a)
+}
+
+// resourcePatterns lists every pattern that applies to the identifier
+// so matching policies can be found with a single indexed query
+func resourcePatterns(id string) []string {
+
+	patterns := []string{id}
+
+	for i, c := range id {
+		if c == '/' && i != len(id)-1 {
+			patterns = append(patterns, id[:i+1], id[:i+1]+"*")
+		}
+	}
+
+	if strings.HasSuffix(id, "/") {
+		patterns = append(patterns, id+"*")
+	}
+
+	return patterns
+}
+
+// resourceSpecificity ranks patterns so an exact identifier outranks any namespace
+// and deeper namespaces outrank their parents
+func resourceSpecificity(pattern string) int {
+
+	if isNamespacePattern(pattern) {
+		return len(strings.TrimSuffix(pattern, "*"))
+	}
+
+	return len(pattern) + 1
+}
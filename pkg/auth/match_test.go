@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"testing"
+)
+
+func TestMatchAction(t *testing.T) {
+
+	cases := []struct {
+		pattern string
+		action  string
+		match   bool
+	}{
+		{"*", "s3:GetObject", true},
+		{"s3:*", "s3:GetObject", true},
+		{"s3:*", "mds:GetIdentifier", false},
+		{"s3:Get*", "s3:GetObject", true},
+		{"s3:Get*", "s3:ListObjects", false},
+		{"s3:GetObject", "s3:GetObject", true},
+		{"s3:GetObject", "S3:getobject", true},
+		{"s3:GetObject", "s3:GetObjects", false},
+		{"mds:*", "mds:CreateIdentifier", true},
+	}
+
+	for _, c := range cases {
+		if matchAction(c.pattern, c.action) != c.match {
+			t.Errorf("matchAction(%q, %q) expected %t", c.pattern, c.action, c.match)
+		}
+	}
+
+}
+
+func TestValidActionPattern(t *testing.T) {
+
+	for _, pattern := range []string{"*", "s3:*", "s3:Get*", "s3:GetObject"} {
+		if err := validActionPattern(pattern); err != nil {
+			t.Errorf("Valid Action %q Rejected: %s", pattern, err.Error())
+		}
+	}
+
+	for _, pattern := range []string{"", "*:GetObject", "s3:*Object"} {
+		if err := validActionPattern(pattern); err == nil {
+			t.Errorf("Invalid Action %q Accepted", pattern)
+		}
+	}
+
+}
+
+func TestActionPatternsOverlap(t *testing.T) {
+
+	cases := []struct {
+		a       string
+		b       string
+		overlap bool
+	}{
+		{"*", "s3:GetObject", true},
+		{"s3:*", "s3:Get*", true},
+		{"s3:Get*", "s3:GetObject", true},
+		{"s3:Get*", "s3:List*", false},
+		{"s3:*", "mds:*", false},
+		{"s3:GetObject", "s3:ListObjects", false},
+	}
+
+	for _, c := range cases {
+		if actionPatternsOverlap(c.a, c.b) != c.overlap || actionPatternsOverlap(c.b, c.a) != c.overlap {
+			t.Errorf("actionPatternsOverlap(%q, %q) expected %t", c.a, c.b, c.overlap)
+		}
+	}
+
+}
+
+func TestMatchResource(t *testing.T) {
+
+	cases := []struct {
+		pattern string
+		id      string
+		match   bool
+	}{
+		{"ark:99999/test", "ark:99999/test", true},
+		{"ark:99999/test", "ark:99999/test2", false},
+		{"ark:99999/*", "ark:99999/test", true},
+		{"ark:99999/*", "ark:99999/project-a/test", true},
+		{"ark:99999/project-a/", "ark:99999/project-a/test", true},
+		{"ark:99999/project-a/", "ark:99999/project-b/test", false},
+		{"ark:99999/*", "ark:88888/test", false},
+	}
+
+	for _, c := range cases {
+		if matchResource(c.pattern, c.id) != c.match {
+			t.Errorf("matchResource(%q, %q) expected %t", c.pattern, c.id, c.match)
+		}
+	}
+
+}
+
+func TestResourcePatterns(t *testing.T) {
+
+	patterns := resourcePatterns("ark:99999/project-a/test")
+
+	for _, expected := range []string{"ark:99999/project-a/test", "ark:99999/project-a/", "ark:99999/project-a/*", "ark:99999/", "ark:99999/*"} {
+		if !contains(patterns, expected) {
+			t.Errorf("Missing Pattern %q in %+v", expected, patterns)
+		}
+	}
+
+	for _, pattern := range patterns {
+		if !matchResource(pattern, "ark:99999/project-a/test") {
+			t.Errorf("Pattern %q does not Match its Identifier", pattern)
+		}
+	}
+
+}
@@ -0,0 +1,611 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+	bson "go.mongodb.org/mongo-driver/bson"
+	primitive "go.mongodb.org/mongo-driver/bson/primitive"
+	mongo "go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	effectAllow = "Allow"
+	effectDeny  = "Deny"
+)
+
+// Policy grants or denies a set of principals the listed actions on a resource
+type Policy struct {
+	ID        string     `json:"@id" bson:"@id"`
+	Type      string     `json:"@type" bson:"@type"`
+	Resource  string     `json:"resource" bson:"resource"`
+	Principal []string   `json:"principal" bson:"principal"`
+	Effect    string     `json:"effect" bson:"effect"`
+	Action    []string   `json:"action" bson:"action"`
+	Issuer    string     `json:"issuer" bson:"issuer"`
+	Condition *Condition `json:"condition,omitempty" bson:"condition,omitempty"`
+	Overrides []string   `json:"overrides,omitempty" bson:"overrides,omitempty"`
+}
+
+// validate checks the required fields of a policy before it is persisted
+func (p Policy) validate() error {
+
+	if p.Resource == "" {
+		return fmt.Errorf("%w: Policy missing Resource", errModelMissingField)
+	}
+
+	if len(p.Principal) == 0 {
+		return fmt.Errorf("%w: Policy missing Principal", errModelMissingField)
+	}
+
+	if len(p.Action) == 0 {
+		return fmt.Errorf("%w: Policy missing Action", errModelMissingField)
+	}
+
+	if err := validResourcePattern(p.Resource); err != nil {
+		return err
+	}
+
+	if p.Effect != effectAllow && p.Effect != effectDeny {
+		return fmt.Errorf("%w: Policy Effect must be Allow or Deny", errModelFieldValidation)
+	}
+
+	for _, action := range p.Action {
+		if err := validActionPattern(action); err != nil {
+			return err
+		}
+	}
+
+	if p.Condition != nil {
+		return p.Condition.validate()
+	}
+
+	return nil
+}
+
+// canonicalizePrincipals normalizes orcid principals and replaces the orcid iD
+// of a known user with their internal id, so either form names the same user
+func (p *Policy) canonicalizePrincipals() error {
+
+	var orcids []string
+
+	for i, principal := range p.Principal {
+		normalized := normalizePrincipal(principal)
+		p.Principal[i] = normalized
+
+		if strings.HasPrefix(normalized, orcidPrefix) {
+			orcids = append(orcids, normalized)
+		}
+	}
+
+	if len(orcids) == 0 {
+		return nil
+	}
+
+	users, err := listUsersByID(orcids)
+	if err != nil {
+		return err
+	}
+
+	for _, u := range users {
+		for i, principal := range p.Principal {
+			if principal == orcidPrefix+u.ORCID {
+				p.Principal[i] = u.ID
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateActions checks the actions against the registry and the type of the targeted resource
+func (p Policy) validateActions() error {
+
+	registry, err := listActions("")
+	if err != nil {
+		return err
+	}
+
+	target := Resource{ID: p.Resource}
+	err = target.get()
+
+	if err != nil && err != mongo.ErrNoDocuments {
+		return fmt.Errorf("%w: %s", errMongoQuery, err.Error())
+	}
+
+	return validatePolicyActions(p, registry, target.ResourceType)
+}
+
+// overlaps reports if two policies share a resource, an action and a principal,
+// and their conditions can hold for the same request. A namespace shares every identifier beneath it
+func (p Policy) overlaps(other Policy) bool {
+
+	if !resourcesOverlap(p.Resource, other.Resource) || !p.Condition.overlaps(other.Condition) {
+		return false
+	}
+
+	if !(contains(p.Principal, "*") || contains(other.Principal, "*") || containsAny(p.Principal, other.Principal)) {
+		return false
+	}
+
+	return actionsOverlap(p.Action, other.Action)
+}
+
+// conflicts finds existing policies with the opposite effect that overlap this policy
+func (p Policy) conflicts() (c []Policy, err error) {
+
+	opposite := effectDeny
+	if p.Effect == effectDeny {
+		opposite = effectAllow
+	}
+
+	ctx, cancel, client, err := connectMongo()
+	defer cancel()
+
+	if err != nil {
+		err = fmt.Errorf("%w: %s", errMongoClient, err.Error())
+		return
+	}
+
+	collection := client.Database(mongoDatabase).Collection(mongoCollection)
+
+	// policies on the resource or a namespace containing it, and for a namespace those beneath it
+	resources := bson.A{bson.D{{"resource", bson.D{{"$in", resourcePatterns(p.Resource)}}}}}
+	if isNamespacePattern(p.Resource) {
+		prefix := strings.TrimSuffix(p.Resource, "*")
+		resources = append(resources, bson.D{{"resource", primitive.Regex{Pattern: "^" + regexp.QuoteMeta(prefix)}}})
+	}
+
+	cur, err := collection.Find(ctx, bson.D{
+		{"@type", typePolicy},
+		{"$or", resources},
+		{"effect", opposite},
+	})
+	if err != nil {
+		err = fmt.Errorf("%w: %s", errMongoQuery, err.Error())
+		return
+	}
+	defer cur.Close(ctx)
+
+	var candidates []Policy
+	err = cur.All(ctx, &candidates)
+	if err != nil {
+		err = fmt.Errorf("%w: %s", errMongoDecode, err.Error())
+		return
+	}
+
+	for _, candidate := range candidates {
+		if p.overlaps(candidate) {
+			c = append(c, candidate)
+		}
+	}
+
+	return
+}
+
+func (p *Policy) create() (err error) {
+
+	err = p.validate()
+	if err != nil {
+		return
+	}
+
+	policyID, err := uuid.NewUUID()
+	if err != nil {
+		return fmt.Errorf("%w: %s", errUUID, err.Error())
+	}
+
+	p.ID = policyID.String()
+	p.Type = typePolicy
+
+	ctx, cancel, client, err := connectMongo()
+	defer cancel()
+
+	if err != nil {
+		return fmt.Errorf("%w: %s", errMongoClient, err.Error())
+	}
+
+	collection := client.Database(mongoDatabase).Collection(mongoCollection)
+
+	_, err = collection.InsertOne(ctx, p)
+
+	if errorDocumentExists(err) {
+		return errDocumentExists
+	}
+
+	return
+}
+
+func (p *Policy) get() (err error) {
+
+	ctx, cancel, client, err := connectMongo()
+	defer cancel()
+
+	if err != nil {
+		return fmt.Errorf("%w: %s", errMongoClient, err.Error())
+	}
+
+	collection := client.Database(mongoDatabase).Collection(mongoCollection)
+	err = collection.FindOne(ctx, bson.D{{"@id", p.ID}, {"@type", typePolicy}}).Decode(&p)
+
+	if err == mongo.ErrNoDocuments {
+		err = errNoDocument
+	}
+
+	return
+}
+
+func (p *Policy) delete() (err error) {
+
+	ctx, cancel, client, err := connectMongo()
+	defer cancel()
+
+	if err != nil {
+		return fmt.Errorf("%w: %s", errMongoClient, err.Error())
+	}
+
+	collection := client.Database(mongoDatabase).Collection(mongoCollection)
+	err = collection.FindOneAndDelete(ctx, bson.D{{"@id", p.ID}, {"@type", typePolicy}}).Decode(&p)
+
+	if err == mongo.ErrNoDocuments {
+		err = errNoDocument
+	}
+
+	return
+}
+
+// policyFilter narrows the policies returned by listPolicies, empty fields are ignored.
+// Visible restricts callers who may not read every policy to those naming one of
+// their principals or on one of the resources they own
+type policyFilter struct {
+	Principals []string
+	Resources  []string
+	Action     string
+	Visible    *policyVisibility
+}
+
+type policyVisibility struct {
+	Principals []string
+	Owned      []string
+}
+
+func (f policyFilter) query() bson.D {
+
+	query := bson.D{{"@type", typePolicy}}
+
+	if len(f.Principals) != 0 {
+		query = append(query, bson.E{"principal", bson.D{{"$in", f.Principals}}})
+	}
+
+	if len(f.Resources) != 0 {
+		query = append(query, bson.E{"resource", bson.D{{"$in", f.Resources}}})
+	}
+
+	if f.Visible != nil {
+		query = append(query, bson.E{"$or", bson.A{
+			bson.D{{"principal", bson.D{{"$in", f.Visible.Principals}}}},
+			bson.D{{"resource", bson.D{{"$in", f.Visible.Owned}}}},
+		}})
+	}
+
+	return query
+}
+
+// matches applies the filters that cannot be expressed as a mongo query
+func (f policyFilter) matches(p Policy) bool {
+
+	if f.Action != "" && !matchAnyAction(p.Action, f.Action) {
+		return false
+	}
+
+	return true
+}
+
+func listPolicies(f policyFilter) (p []Policy, err error) {
+
+	mongoCtx, cancel, client, err := connectMongo()
+	defer cancel()
+
+	if err != nil {
+		err = fmt.Errorf("%w: %s", errMongoClient, err.Error())
+		return
+	}
+
+	collection := client.Database(mongoDatabase).Collection(mongoCollection)
+
+	cur, err := collection.Find(mongoCtx, f.query())
+	if err != nil {
+		err = fmt.Errorf("%w: %s", errMongoQuery, err.Error())
+		return
+	}
+	defer cur.Close(mongoCtx)
+
+	var candidates []Policy
+	err = cur.All(mongoCtx, &candidates)
+	if err != nil {
+		err = fmt.Errorf("%w: %s", errMongoDecode, err.Error())
+		return
+	}
+
+	for _, candidate := range candidates {
+		if f.matches(candidate) {
+			p = append(p, candidate)
+		}
+	}
+
+	return
+}
+
+// PolicyCreate is the http handler for creating a policy
+// POST /policy?force=true records the override of any conflicting policies
+func PolicyCreate(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/ld+json")
+
+	var p Policy
+
+	requestBody, err := ioutil.ReadAll(r.Body)
+
+	if err != nil {
+		w.WriteHeader(400)
+		fmt.Fprintf(w, `{"error": "Unable to Read Request Body"}`)
+		return
+	}
+
+	if !json.Valid(requestBody) {
+		w.WriteHeader(400)
+		fmt.Fprintf(w, `{"error": "Invalid JSON Submitted"}`)
+		return
+	}
+
+	err = json.Unmarshal(requestBody, &p)
+
+	if err != nil {
+		w.WriteHeader(400)
+		fmt.Fprintf(w, `{"message": "Failed to Unmarshal Request JSON", "error": "%s"}`, err.Error())
+		return
+	}
+
+	err = p.validate()
+
+	if err == nil {
+		err = p.canonicalizePrincipals()
+	}
+
+	if err == nil {
+		err = p.validateActions()
+	}
+
+	if errors.Is(err, errModelMissingField) || errors.Is(err, errModelFieldValidation) {
+		w.WriteHeader(400)
+		fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+		return
+	}
+
+	if err != nil {
+		w.WriteHeader(500)
+		fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+		return
+	}
+
+	// reject policies contradicting existing ones unless the override is forced
+	conflicting, err := p.conflicts()
+
+	if err != nil {
+		w.WriteHeader(500)
+		fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+		return
+	}
+
+	if len(conflicting) != 0 {
+		conflictIDs := make([]string, len(conflicting))
+		for i, c := range conflicting {
+			conflictIDs[i] = c.ID
+		}
+
+		force, _ := strconv.ParseBool(r.URL.Query().Get("force"))
+
+		if !force {
+			w.WriteHeader(409)
+			responseBody, _ := json.Marshal(map[string]interface{}{
+				"error":     "Policy Conflicts With Existing Policies",
+				"conflicts": conflictIDs,
+			})
+			w.Write(responseBody)
+			return
+		}
+
+		p.Overrides = conflictIDs
+	}
+
+	err = p.create()
+
+	if err == nil {
+		w.WriteHeader(201)
+		responseBody, _ := json.Marshal(p)
+		w.Write(responseBody)
+		return
+	}
+
+	if errors.Is(err, errModelMissingField) || errors.Is(err, errModelFieldValidation) {
+		w.WriteHeader(400)
+		fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+		return
+	}
+
+	if errors.Is(err, errDocumentExists) {
+		w.WriteHeader(400)
+		fmt.Fprintf(w, `{"error": "Policy Already Exists", "@id": "%s"}`, p.ID)
+		return
+	}
+
+	w.WriteHeader(500)
+	fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+	return
+
+}
+
+// PolicyGet is the http handler for retrieving a single policy the caller may read
+// GET /policy/:policyID
+func PolicyGet(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/ld+json")
+
+	var p Policy
+
+	params := httprouter.ParamsFromContext(r.Context())
+	p.ID = params.ByName("policyID")
+
+	err := p.get()
+
+	if errors.Is(err, errNoDocument) {
+		w.WriteHeader(404)
+		fmt.Fprintf(w, `{"error": "Policy Not Found", "@id": "%s"}`, p.ID)
+		return
+	}
+
+	if err != nil {
+		w.WriteHeader(500)
+		fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+		return
+	}
+
+	if caller, ok := callerFromContext(r.Context()); ok {
+		readable, err := caller.readsPolicy(p)
+
+		if err != nil {
+			w.WriteHeader(500)
+			fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+			return
+		}
+
+		if !readable {
+			w.WriteHeader(403)
+			fmt.Fprintf(w, `{"error": "Forbidden", "@id": "%s"}`, caller.ID)
+			return
+		}
+	}
+
+	responseBody, _ := json.Marshal(p)
+	w.WriteHeader(200)
+	w.Write(responseBody)
+	return
+
+}
+
+// PolicyList is the http handler for listing and filtering policies, admins and curators
+// list every policy, others only those naming them or on resources they own
+// GET /policy?user=<userID>&resource=<identifier>&action=<action>
+func PolicyList(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/ld+json")
+
+	var err error
+
+	query := r.URL.Query()
+	filter := policyFilter{
+		Action: query.Get("action"),
+	}
+
+	if resource := query.Get("resource"); resource != "" {
+		filter.Resources = []string{resource}
+	}
+
+	// expand the user into every principal a policy could name them by
+	if userID := query.Get("user"); userID != "" {
+
+		// users may list their own policies, admins and curators anyone's
+		if caller, ok := callerFromContext(r.Context()); ok && caller.ID != userID && !caller.hasRole(roleAdmin, roleCurator) {
+			w.WriteHeader(403)
+			fmt.Fprintf(w, `{"error": "Forbidden", "@id": "%s"}`, caller.ID)
+			return
+		}
+
+		u := User{ID: userID}
+		err = u.get()
+
+		if err == mongo.ErrNoDocuments {
+			w.WriteHeader(404)
+			fmt.Fprintf(w, `{"error": "User Not Found", "@id": "%s"}`, userID)
+			return
+		}
+
+		if err != nil {
+			w.WriteHeader(500)
+			fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+			return
+		}
+
+		filter.Principals = u.principals()
+	}
+
+	// members only see the policies naming them or on resources they own
+	if caller, ok := callerFromContext(r.Context()); ok && !caller.hasRole(roleAdmin, roleCurator) {
+		owned, err := listResourcesReachable(nil, nil, caller.owners())
+
+		if err != nil {
+			w.WriteHeader(500)
+			fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+			return
+		}
+
+		filter.Visible = &policyVisibility{Principals: caller.principals(), Owned: []string{}}
+		for _, resource := range owned {
+			filter.Visible.Owned = append(filter.Visible.Owned, resource.ID)
+		}
+	}
+
+	policies, err := listPolicies(filter)
+
+	if err != nil {
+		w.WriteHeader(500)
+		fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+		return
+	}
+
+	if policies == nil {
+		policies = []Policy{}
+	}
+
+	responseBody, _ := json.Marshal(policies)
+	w.WriteHeader(200)
+	w.Write(responseBody)
+	return
+
+}
+
+// PolicyDelete is the http handler for deleting a single policy
+// DELETE /policy/:policyID
+func PolicyDelete(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/ld+json")
+
+	var p Policy
+
+	params := httprouter.ParamsFromContext(r.Context())
+	p.ID = params.ByName("policyID")
+
+	err := p.delete()
+
+	if errors.Is(err, errNoDocument) {
+		w.WriteHeader(404)
+		fmt.Fprintf(w, `{"error": "Policy Not Found", "@id": "%s"}`, p.ID)
+		return
+	}
+
+	if err != nil {
+		w.WriteHeader(500)
+		fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+		return
+	}
+
+	responseBody, _ := json.Marshal(p)
+	w.WriteHeader(200)
+	w.Write(responseBody)
+	return
+
+}
@@ -0,0 +1,148 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPolicyMethods(t *testing.T) {
+
+	p := Policy{
+		Resource:  "ark:99999/policytest",
+		Principal: []string{"orcid:1234-1234-1234-1234", "*"},
+		Effect:    effectAllow,
+		Action:    []string{"s3:GetObject"},
+		Issuer:    "test",
+	}
+
+	t.Run("Create", func(t *testing.T) {
+		err := p.create()
+		if err != nil {
+			t.Fatalf("Failed to Create Policy: %s", err.Error())
+		}
+	})
+
+	t.Run("Get", func(t *testing.T) {
+		found := Policy{ID: p.ID}
+		err := found.get()
+		if err != nil {
+			t.Fatalf("Failed to Find Policy: %s", err.Error())
+		}
+
+		if found.Resource != p.Resource {
+			t.Fatalf("Found Policy has wrong Resource: %+v", found)
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		plist, err := listPolicies(policyFilter{
+			Principals: []string{"orcid:1234-1234-1234-1234"},
+			Resources:  []string{p.Resource},
+			Action:     "s3:GetObject",
+		})
+		if err != nil {
+			t.Fatalf("Failed to List Policies: %s", err.Error())
+		}
+
+		if len(plist) != 1 {
+			t.Fatalf("Expected one Policy, found: %+v", plist)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		del := Policy{ID: p.ID}
+		err := del.delete()
+		if err != nil {
+			t.Fatalf("Failed to Delete Policy: %s", err.Error())
+		}
+	})
+
+}
+
+func TestPolicyValidate(t *testing.T) {
+
+	valid := Policy{
+		Resource:  "ark:99999/test",
+		Principal: []string{"*"},
+		Effect:    effectDeny,
+		Action:    []string{"*"},
+	}
+
+	if err := valid.validate(); err != nil {
+		t.Fatalf("Valid Policy Rejected: %s", err.Error())
+	}
+
+	badEffect := valid
+	badEffect.Effect = "Maybe"
+
+	if err := badEffect.validate(); err == nil {
+		t.Fatalf("Policy with Effect Maybe was not Rejected")
+	}
+
+	missingAction := valid
+	missingAction.Action = nil
+
+	if err := missingAction.validate(); err == nil {
+		t.Fatalf("Policy without Actions was not Rejected")
+	}
+
+}
+
+func TestPolicyOverlaps(t *testing.T) {
+
+	allow := Policy{
+		Resource:  "ark:99999/test",
+		Principal: []string{"group:1", "orcid:1"},
+		Effect:    effectAllow,
+		Action:    []string{"s3:GetObject", "s3:ListObjects"},
+	}
+
+	t.Run("SharedPrincipalAndAction", func(t *testing.T) {
+		deny := Policy{Resource: allow.Resource, Principal: []string{"orcid:1"}, Effect: effectDeny, Action: []string{"s3:GetObject"}}
+		if !allow.overlaps(deny) {
+			t.Fatalf("Policies sharing Principal and Action do not Overlap")
+		}
+	})
+
+	t.Run("Wildcards", func(t *testing.T) {
+		deny := Policy{Resource: allow.Resource, Principal: []string{"*"}, Effect: effectDeny, Action: []string{"*"}}
+		if !allow.overlaps(deny) {
+			t.Fatalf("Wildcard Policy does not Overlap")
+		}
+	})
+
+	t.Run("DisjointPrincipals", func(t *testing.T) {
+		deny := Policy{Resource: allow.Resource, Principal: []string{"orcid:2"}, Effect: effectDeny, Action: []string{"s3:GetObject"}}
+		if allow.overlaps(deny) {
+			t.Fatalf("Policies with Disjoint Principals Overlap")
+		}
+	})
+
+	t.Run("DifferentResource", func(t *testing.T) {
+		deny := Policy{Resource: "ark:99999/other", Principal: []string{"orcid:1"}, Effect: effectDeny, Action: []string{"*"}}
+		if allow.overlaps(deny) {
+			t.Fatalf("Policies on Different Resources Overlap")
+		}
+	})
+
+	t.Run("TrivialCondition", func(t *testing.T) {
+		epoch := time.Unix(0, 0).UTC()
+		deny := Policy{Resource: allow.Resource, Principal: []string{"orcid:1"}, Effect: effectDeny, Action: []string{"*"}, Condition: &Condition{NotBefore: &epoch}}
+		if !allow.overlaps(deny) {
+			t.Fatalf("Conditional Policy does not Overlap")
+		}
+	})
+
+	t.Run("Namespace", func(t *testing.T) {
+		deny := Policy{Resource: "ark:99999/*", Principal: []string{"orcid:1"}, Effect: effectDeny, Action: []string{"*"}}
+		if !allow.overlaps(deny) || !deny.overlaps(allow) {
+			t.Fatalf("Namespace Policy does not Overlap a Policy Beneath it")
+		}
+
+		other := Policy{Resource: "ark:88888/*", Principal: []string{"orcid:1"}, Effect: effectDeny, Action: []string{"*"}}
+		if allow.overlaps(other) {
+			t.Fatalf("Policy Overlaps a Namespace not Containing it")
+		}
+	})
+
+}
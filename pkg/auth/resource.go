@@ -7,16 +7,26 @@ import (
 	"errors"
 	"encoding/json"
 	"log"
+	"regexp"
 	"fmt"
+	"time"
 	bson "go.mongodb.org/mongo-driver/bson"		
+	mongo "go.mongodb.org/mongo-driver/mongo"
+	options "go.mongodb.org/mongo-driver/mongo/options"
 	"github.com/julienschmidt/httprouter"
 )
 
 
+// challengesSuffix marks a request for the audit trail of a resource
+// as resource ids contain slashes it cannot be a separate route
+const challengesSuffix = "/challenges"
+
 // Resource is a structure for documenting entities contained in the framework
 type Resource struct {
 	ID    	string `json:"@id" bson:"@id"`
 	Type  	string `json:"@type" bson:"@type"` 
+	// ResourceType is the service resource type, such as s3:Bucket or mds:Identifier
+	ResourceType string `json:"resourceType,omitempty" bson:"resourceType,omitempty"`
 	Owner 	string `json:"owner" bson:"owner"`
 	Users 	[]string `json:"users" bson:"users"`
 	Group 	[]string `json:"groups" bson: "groups"`
@@ -42,6 +52,17 @@ func (r *Resource) create() error {
 
 	//TODO:  prove owner exists
 
+	// an identifier already in use by any record cannot be registered again,
+	// the unique index covers concurrent requests
+	err = collection.FindOne(ctx, bson.D{{"@id", r.ID}}).Err()
+	if err == nil {
+		return errDocumentExists
+	}
+
+	if err != mongo.ErrNoDocuments {
+		return fmt.Errorf("%w: %s", errMongoQuery, err.Error())
+	}
+
 	// create document
 	_, err = collection.InsertOne(ctx, r)
 
@@ -67,7 +88,7 @@ func (r *Resource) get() error {
 	}
 
 	collection := client.Database(mongoDatabase).Collection(mongoCollection)
-	err = collection.FindOne(ctx, bson.D{{"@id", r.ID}}).Decode(&r)
+	err = collection.FindOne(ctx, bson.D{{"@id", r.ID}, {"@type", typeResource}}).Decode(&r)
 
 	return err
 
@@ -89,7 +110,7 @@ func (r *Resource) delete() error {
 	collection := client.Database(mongoDatabase).Collection(mongoCollection)
 
 	// Query for the Resource, prove it exists
-	err = collection.FindOne(ctx, bson.D{{"@id", r.ID}}).Decode(&r)
+	err = collection.FindOne(ctx, bson.D{{"@id", r.ID}, {"@type", typeResource}}).Decode(&r)
 	if err != nil {
 		return fmt.Errorf("DeleteResourceError: Group Not Found: %w", err)
 	}
@@ -113,6 +134,113 @@ func (r *Resource) delete() error {
 	return nil
 }
 
+// enclosingClaims finds the namespace resources enclosing the identifier
+// and reports if any policy already applies to it
+func enclosingClaims(id string) (namespaces []Resource, governed bool, err error) {
+
+	ctx, cancel, client, err := connectMongo()
+	defer cancel()
+
+	if err != nil {
+		err = fmt.Errorf("%w: %s", errMongoClient, err.Error())
+		return
+	}
+
+	collection := client.Database(mongoDatabase).Collection(mongoCollection)
+
+	patterns := resourcePatterns(id)
+
+	cur, err := collection.Find(ctx, bson.D{{"@type", typeResource}, {"@id", bson.D{{"$in", patterns[1:]}}}})
+	if err != nil {
+		err = fmt.Errorf("%w: %s", errMongoQuery, err.Error())
+		return
+	}
+	defer cur.Close(ctx)
+
+	err = cur.All(ctx, &namespaces)
+	if err != nil {
+		err = fmt.Errorf("%w: %s", errMongoDecode, err.Error())
+		return
+	}
+
+	policies, err := collection.CountDocuments(ctx, bson.D{{"@type", typePolicy}, {"resource", bson.D{{"$in", patterns}}}})
+	if err != nil {
+		err = fmt.Errorf("%w: %s", errMongoQuery, err.Error())
+		return
+	}
+
+	return namespaces, policies != 0, nil
+}
+
+// createResourceIndex keeps resource identifiers unique
+func createResourceIndex() {
+
+	ctx, cancel, client, err := connectMongo()
+	defer cancel()
+
+	if err != nil {
+		log.Printf("ResourceInit: Failed to Connect to Mongo\t Error: %s", err.Error())
+		return
+	}
+
+	collection := client.Database(mongoDatabase).Collection(mongoCollection)
+
+	_, err = collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{"@id", 1}},
+		Options: options.Index().SetName("resource_id").SetUnique(true).SetPartialFilterExpression(bson.D{{"@type", typeResource}}),
+	})
+
+	if err != nil {
+		log.Printf("Setting Up Resource Index: %s", err.Error())
+	}
+}
+
+// listChallenges returns the authorization decisions made about this resource
+func (r Resource) listChallenges(from time.Time, to time.Time) (c []Challenge, err error) {
+	return listChallenges(challengeFilter{Object: r.ID, From: from, To: to})
+}
+
+// listResourcesReachable returns the resources with one of the ids, beneath one of
+// the namespace patterns, or owned by one of the owners
+func listResourcesReachable(ids []string, namespaces []string, owners []string) (r []Resource, err error) {
+
+	mongoCtx, cancel, client, err := connectMongo()
+	defer cancel()
+
+	if err != nil {
+		err = fmt.Errorf("%w: %s", errMongoClient, err.Error())
+		return
+	}
+
+	collection := client.Database(mongoDatabase).Collection(mongoCollection)
+
+	reachable := bson.A{bson.D{{"owner", bson.D{{"$in", owners}}}}}
+
+	if len(ids) != 0 {
+		reachable = append(reachable, bson.D{{"@id", bson.D{{"$in", ids}}}})
+	}
+
+	for _, namespace := range namespaces {
+		prefix := regexp.QuoteMeta(strings.TrimSuffix(namespace, "*"))
+		reachable = append(reachable, bson.D{{"@id", bson.D{{"$regex", "^" + prefix}}}})
+	}
+
+	query := bson.D{{"@type", typeResource}, {"$or", reachable}}
+	cur, err := collection.Find(mongoCtx, query)
+	if err != nil {
+		err = fmt.Errorf("%w: %s", errMongoQuery, err.Error())
+		return
+	}
+	defer cur.Close(mongoCtx)
+
+	err = cur.All(mongoCtx, &r)
+	if err != nil {
+		err = fmt.Errorf("%w: %s", errMongoDecode, err.Error())
+	}
+
+	return
+}
+
 func listResources() (r []Resource, err error) {
 
 	mongoCtx, cancel, client, err := connectMongo()
@@ -168,6 +296,27 @@ func ResourceCreate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// the owner in the body is only trusted from admins and curators
+	if caller, ok := callerFromContext(r.Context()); ok {
+		res.Owner = caller.ownerFor(res.Owner)
+
+		namespaces, governed, err := enclosingClaims(res.ID)
+
+		if err != nil {
+			w.Header().Set("Content-Type", "application/ld+json")
+			w.WriteHeader(500)
+			fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+			return
+		}
+
+		if !caller.mayRegister(res.ID, namespaces, governed) {
+			w.Header().Set("Content-Type", "application/ld+json")
+			w.WriteHeader(403)
+			fmt.Fprintf(w, `{"error": "Forbidden", "@id": "%s"}`, res.ID)
+			return
+		}
+	}
+
 	err = res.create()
 
 	if err == nil {
@@ -181,7 +330,7 @@ func ResourceCreate(w http.ResponseWriter, r *http.Request) {
 	if errors.Is(err, errDocumentExists) {
 		w.WriteHeader(400)
 		w.Header().Set("Content-Type", "application/ld+json")
-		w.Write([]byte(`{"error": "User Already Exists" ,"@id": "` + res.ID + `"}`))
+		w.Write([]byte(`{"error": "Resource Already Exists" ,"@id": "` + res.ID + `"}`))
 		return
 	}
 
@@ -205,6 +354,32 @@ func ResourceGet(w http.ResponseWriter, r *http.Request) {
 	resource.ID = strings.TrimPrefix(params.ByName("resourceID"), "/")
 
 	log.Printf("ResourceID: %s", resource.ID)
+
+	// the catch all route also serves /resource/*resourceID/challenges,
+	// the audit trail is only read by the resource owner, admins and curators
+	if strings.HasSuffix(resource.ID, challengesSuffix) {
+
+		if caller, ok := callerFromContext(r.Context()); ok && !caller.hasRole(roleAdmin, roleCurator) {
+			owns, err := caller.ownsResource(strings.TrimSuffix(resource.ID, challengesSuffix))
+
+			if err != nil {
+				w.Header().Set("Content-Type", "application/ld+json")
+				w.WriteHeader(500)
+				fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+				return
+			}
+
+			if !owns {
+				w.Header().Set("Content-Type", "application/ld+json")
+				w.WriteHeader(403)
+				fmt.Fprintf(w, `{"error": "Forbidden", "@id": "%s"}`, caller.ID)
+				return
+			}
+		}
+
+		ResourceChallenges(w, r)
+		return
+	}
 	
 	err = resource.get()
 
@@ -227,6 +402,28 @@ func ResourceGet(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// ResourceChallenges is the http handler for listing the authorization decisions made about a resource
+// GET /resource/*resourceID/challenges?from=<RFC3339>&to=<RFC3339>
+func ResourceChallenges(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/ld+json")
+
+	var resource Resource
+
+	params := httprouter.ParamsFromContext(r.Context())
+	resource.ID = strings.TrimSuffix(strings.TrimPrefix(params.ByName("resourceID"), "/"), challengesSuffix)
+
+	filter, err := parseChallengeFilter(r)
+
+	if err != nil {
+		w.WriteHeader(400)
+		fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+		return
+	}
+
+	challenges, err := resource.listChallenges(filter.From, filter.To)
+	writeChallenges(w, challenges, err)
+}
+
 // ResourceDelete is the http handler for deleting a single resource by ID
 func ResourceDelete(w http.ResponseWriter, r *http.Request) {
 
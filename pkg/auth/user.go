@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"context"
 	"net/http"
 	"io/ioutil"
 	"strings"
@@ -9,8 +10,13 @@ import (
 	"github.com/julienschmidt/httprouter"
 	"log"
 	"fmt"
+	"net/mail"
+	"encoding/base64"
+	"regexp"
+	"strconv"
 	"github.com/google/uuid"
 	bson "go.mongodb.org/mongo-driver/bson"
+	primitive "go.mongodb.org/mongo-driver/bson/primitive"
 	mongo "go.mongodb.org/mongo-driver/mongo"
 	options "go.mongodb.org/mongo-driver/mongo/options"
 
@@ -19,17 +25,24 @@ import (
     "os"
 )
 
-var jwtSecret []byte
+// sessionLifetime is how long a session token is valid, and so how long a retired key must verify
+const sessionLifetime = 48 * time.Hour
 
-func init() {
+// session tokens name this service as issuer and the fairscape services as audience
+var (
+	tokenIssuer   = "https://fairscape.org/auth"
+	tokenAudience = "https://fairscape.org"
+)
 
-    jwtENV, ok := os.LookupEnv("JWT_SECRET")
+func init() {
 
-    if !ok {
-        jwtENV = "test secret"
+    if issuer, ok := os.LookupEnv("JWT_ISSUER"); ok {
+        tokenIssuer = issuer
     }
 
-    jwtSecret = []byte(jwtENV)
+    if audience, ok := os.LookupEnv("JWT_AUDIENCE"); ok {
+        tokenAudience = audience
+    }
 }
 
 func createUserIndex() {
@@ -39,6 +52,7 @@ func createUserIndex() {
 
 	if err != nil {
 		log.Printf("UserInit: Failed to Connect to Mongo\t Error: %s", err.Error())
+		return
 	}
 
 	collection := client.Database(mongoDatabase).Collection(mongoCollection)
@@ -59,6 +73,179 @@ func createUserIndex() {
 		log.Printf("Setting Up User Index: %s", err.Error())
 	}
 
+	// a globus identity may only be linked to a single user
+	models := []mongo.IndexModel{
+		{
+			Keys: bson.D{{"sub", 1}},
+			Options: options.Index().SetName("sub").SetUnique(true).SetPartialFilterExpression(
+				bson.D{{"@type", typeUser}, {"sub", bson.D{{"$exists", true}}}},
+			),
+		},
+		{
+			Keys: bson.D{{"identities.id", 1}},
+			Options: options.Index().SetName("identities").SetUnique(true).SetPartialFilterExpression(
+				bson.D{{"@type", typeUser}, {"identities.id", bson.D{{"$exists", true}}}},
+			),
+		},
+	}
+
+	// indexes backing the filters and sort orders of the user listing
+	for _, field := range []string{"role", "groups", "name", "email"} {
+		models = append(models, mongo.IndexModel{
+			Keys:    bson.D{{field, 1}, {"@id", 1}},
+			Options: options.Index().SetName("user_" + field).SetPartialFilterExpression(bson.D{{"@type", typeUser}}),
+		})
+	}
+
+	// an orcid iD verified through globus identifies a single user
+	models = append(models, mongo.IndexModel{
+		Keys: bson.D{{"orcid", 1}},
+		Options: options.Index().SetName("orcid").SetUnique(true).SetPartialFilterExpression(
+			bson.D{{"@type", typeUser}, {"orcid", bson.D{{"$gt", ""}}}},
+		),
+	})
+
+	_, err = collection.Indexes().CreateMany(ctx, models, opts)
+
+	if err != nil {
+		log.Printf("Setting Up User Indexes: %s", err.Error())
+	}
+
+	if err = normalizeRoles(ctx, collection); err != nil {
+		log.Printf("Normalizing User Roles: %s", err.Error())
+	}
+
+}
+
+// normalizeRoles lowercases the roles of users created before roles were stored lowercase,
+// so the role filter can match them exactly
+func normalizeRoles(ctx context.Context, collection *mongo.Collection) error {
+
+	cur, err := collection.Find(ctx, bson.D{{"@type", typeUser}, {"role", primitive.Regex{Pattern: "[A-Z]"}}})
+	if err != nil {
+		return fmt.Errorf("%w: %s", errMongoQuery, err.Error())
+	}
+	defer cur.Close(ctx)
+
+	var users []User
+	if err = cur.All(ctx, &users); err != nil {
+		return fmt.Errorf("%w: %s", errMongoDecode, err.Error())
+	}
+
+	for _, u := range users {
+		_, err = collection.UpdateOne(ctx,
+			bson.D{{"@id", u.ID}, {"@type", typeUser}},
+			bson.D{{"$set", bson.D{{"role", strings.ToLower(u.Role)}}}},
+		)
+		if err != nil {
+			return fmt.Errorf("%w: %s", errMongoQuery, err.Error())
+		}
+	}
+
+	return nil
+}
+
+const (
+	roleAdmin   = "admin"
+	roleCurator = "curator"
+	roleMember  = "member"
+	roleService = "service"
+)
+
+var validRoles = []string{roleAdmin, roleCurator, roleMember, roleService}
+
+// user records without a status predate registration and are active
+const (
+	statusActive    = "active"
+	statusPending   = "pending"
+	statusSuspended = "suspended"
+	statusDeleted   = "deleted"
+)
+
+// StatusChange records who changed the status of a user, when and why
+type StatusChange struct {
+	Status    string    `json:"status" bson:"status"`
+	Reason    string    `json:"reason" bson:"reason"`
+	Actor     string    `json:"actor" bson:"actor"`
+	Timestamp time.Time `json:"timestamp" bson:"timestamp"`
+}
+
+// validate checks the change is one an admin may make, pending is only entered by registration
+func (c StatusChange) validate() error {
+
+	if c.Status != statusActive && c.Status != statusSuspended && c.Status != statusDeleted {
+		return fmt.Errorf("%w: Status must be active, suspended or deleted", errModelFieldValidation)
+	}
+
+	if c.Status != statusActive && strings.TrimSpace(c.Reason) == "" {
+		return fmt.Errorf("%w: A Reason is Required to set Status %s", errModelFieldValidation, c.Status)
+	}
+
+	return nil
+}
+
+// principals may name a user by orcid iD instead of the internal id
+const orcidPrefix = "orcid:"
+
+var orcidPattern = regexp.MustCompile(`^\d{4}-\d{4}-\d{4}-\d{3}[\dX]$`)
+
+// parseORCID extracts the iD from an orcid: principal or an orcid.org URI
+func parseORCID(principal string) (id string, ok bool) {
+
+	lower := strings.ToLower(principal)
+
+	for _, prefix := range []string{orcidPrefix, "https://orcid.org/", "http://orcid.org/"} {
+		if strings.HasPrefix(lower, prefix) {
+			return strings.ToUpper(principal[len(prefix):]), true
+		}
+	}
+
+	return "", false
+}
+
+// normalizePrincipal rewrites any form of a well formed orcid iD as orcid:<iD>,
+// other principals, including ids that merely begin with orcid:, are unchanged
+func normalizePrincipal(principal string) string {
+
+	if id, ok := parseORCID(principal); ok && orcidPattern.MatchString(id) {
+		return orcidPrefix + id
+	}
+
+	return principal
+}
+
+// orcidFromIdentities returns the iD of the first identity issued by orcid
+func orcidFromIdentities(identities []GlobusIdentity) string {
+
+	for _, identity := range identities {
+		id := strings.ToUpper(strings.TrimSuffix(strings.ToLower(identity.Username), "@orcid.org"))
+
+		if strings.HasSuffix(strings.ToLower(identity.Username), "@orcid.org") && orcidPattern.MatchString(id) {
+			return id
+		}
+	}
+
+	return ""
+}
+
+// validRole reports if the role is one of the defined roles, ignoring case
+func validRole(role string) bool {
+	return contains(validRoles, strings.ToLower(role))
+}
+
+// validEmail reports if the value is a bare address with a dotted domain
+func validEmail(email string) bool {
+
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email || address.Name != "" {
+		return false
+	}
+
+	at := strings.LastIndex(email, "@")
+	domain := email[at+1:]
+
+	return strings.Contains(domain, ".") && !strings.Contains(domain, "..") &&
+		!strings.HasPrefix(domain, ".") && !strings.HasSuffix(domain, ".")
 }
 
 // User is a structure for user data with methods for interacting with Mongo
@@ -71,6 +258,17 @@ type User struct {
 	Groups  []string `json:"groups" bson:"groups"`
 	AccessToken  string  `json:"access_token" bson:"access_token"`
 	RefreshToken string	`json:"refresh_token" bson:"refresh_token"`
+	Status  string   `json:"status,omitempty" bson:"status,omitempty"`
+	StatusHistory []StatusChange `json:"statusHistory,omitempty" bson:"statusHistory,omitempty"`
+	Sub           string           `json:"sub,omitempty" bson:"sub,omitempty"`
+	ORCID         string           `json:"orcid,omitempty" bson:"orcid,omitempty"`
+	Identities    []GlobusIdentity `json:"identities,omitempty" bson:"identities,omitempty"`
+	Unlinked      []string         `json:"unlinked_identities,omitempty" bson:"unlinked_identities,omitempty"`
+}
+
+// active reports if the user may log in and act, only active users and those predating status may
+func (u User) active() bool {
+	return u.Status == "" || u.Status == statusActive
 }
 
 type UserTokenClaims struct {
@@ -90,32 +288,84 @@ func (u *User) newSession() (err error) {
 		strings.Join(u.Groups, ";"),
 		jwt.StandardClaims{
 			Subject: u.ID,
-			Audience: "https://fairscape.org",
+			Issuer: tokenIssuer,
+			Audience: tokenAudience,
 			IssuedAt: now.Unix(),
-			ExpiresAt: now.Add(time.Hour * 48).Unix(),
+			ExpiresAt: now.Add(sessionLifetime).Unix(),
 		},
 	}
 
-	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-    u.AccessToken, err = accessToken.SignedString(jwtSecret)
+    u.AccessToken, err = sessionKey().sign(claims)
 
     return
 }
 
-// parseToken takes the string value of the token and returns the user information contained within
+// parseToken returns the user a verified token was issued to
 func parseToken(tokenString string) (u User, err error) {
 
-	token, err := jwt.ParseWithClaims(tokenString, &UserTokenClaims{}, func(token *jwt.Token) (interface {}, error) {
-		// no secret currently
-		return jwtSecret, nil
-	})
+	claims, err := verifyToken(tokenString)
+	if err != nil {
+		return
+	}
+
+	u.ID = claims.Subject
+	u.Role = claims.Role
+
+	if claims.Groups != "" {
+		u.Groups = strings.Split(claims.Groups, ";")
+	}
+
+	return
+}
+
+// verifyToken checks the signing method, signature, expiry, audience and issuer of the token and returns its claims
+func verifyToken(tokenString string) (claims *UserTokenClaims, err error) {
+
+	token, err := jwt.ParseWithClaims(tokenString, &UserTokenClaims{}, verificationKeyFunc)
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errTokenInvalid, err.Error())
+	}
+
+	claims, ok := token.Claims.(*UserTokenClaims)
+	if !ok || !token.Valid || claims.Subject == "" {
+		return nil, errTokenInvalid
+	}
+
+	// the library accepts tokens without expiry, audience or issuer so each is required here
+	if claims.ExpiresAt == 0 {
+		return nil, fmt.Errorf("%w: Token has no Expiry", errTokenInvalid)
+	}
+
+	if !claims.VerifyAudience(tokenAudience, true) {
+		return nil, fmt.Errorf("%w: Token Audience %s", errTokenInvalid, claims.Audience)
+	}
+
+	if !claims.VerifyIssuer(tokenIssuer, true) {
+		return nil, fmt.Errorf("%w: Token Issuer %s", errTokenInvalid, claims.Issuer)
+	}
+
+	return
+}
 
+// requestToken is the bearer token of the request, or the session cookie set at login.
+// Browsers send the cookie with requests other sites make, so it is only read on safe methods
+// and every request that changes state must carry the bearer header
+func requestToken(r *http.Request) string {
+
+	if bearer := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "); bearer != "" {
+		return bearer
+	}
 
-	claims := token.Claims.(*UserTokenClaims)
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return ""
+	}
 
-	log.Printf("%+v", claims)
+	if cookie, err := r.Cookie("fairscapeAuth"); err == nil {
+		return cookie.Value
+	}
 
-	return 
+	return ""
 }
 
 
@@ -145,311 +395,1794 @@ func queryUserEmail(email string) (u User, err error) {
 }
 
 
-func logoutUser(token string) (u User, err error) {
-
-	query := bson.D{{"access_token", token}, {"@type", typeUser}}
-	update := bson.D{{"$set", bson.D{{"access_token", ""}}  }}
+// queryUserIdentity finds the user linked to any of the globus identities
+func queryUserIdentity(ids []string) (u User, err error) {
 
 	ctx, cancel, client, err := connectMongo()
 	defer cancel()
 
 	if err != nil {
+		err = fmt.Errorf("%w: %s", errMongoClient, err.Error())
 		return
 	}
 
 	collection := client.Database(mongoDatabase).Collection(mongoCollection)
 
-	res := collection.FindOneAndUpdate(ctx, query, update)
-
-	err = res.Decode(&u)
+	query := bson.D{
+		{"@type", typeUser},
+		{"$or", bson.A{
+			bson.D{{"sub", bson.D{{"$in", ids}}}},
+			bson.D{{"identities.id", bson.D{{"$in", ids}}}},
+		}},
+	}
+	err = collection.FindOne(ctx, query).Decode(&u)
 
-	// TODO: Error Handling For Following Cases
-	// ErrNilDocument
+	if err == mongo.ErrNoDocuments {
+		err = errNoDocument
+	}
 
 	return
-
 }
 
+// missingIdentities returns the ids not yet linked to the user,
+// identities the user has unlinked are never returned
+func (u User) missingIdentities(ids []string) (missing []string) {
 
-func listUsers() (u []User, err error) {
+	for _, id := range ids {
 
-	mongoCtx, cancel, client, err := connectMongo()
-	defer cancel()
+		if id == "" || contains(u.Unlinked, id) || contains(missing, id) {
+			continue
+		}
 
-	if err != nil {
-		err = fmt.Errorf("%w: %s", errMongoClient, err.Error())
-		return
+		linked := false
+		for _, identity := range u.Identities {
+			if identity.Id == id {
+				linked = true
+				break
+			}
+		}
+
+		if !linked {
+			missing = append(missing, id)
+		}
 	}
 
-	collection := client.Database(mongoDatabase).Collection(mongoCollection)
+	return
+}
 
-	query := bson.D{{"@type", typeUser}}
-	cur, err := collection.Find(mongoCtx, query, nil)
-	defer cur.Close(mongoCtx)
+// linkIdentities stores the primary identity if unset and appends the new identities,
+// an orcid identity becomes the user's verified orcid iD if they have none
+func (u *User) linkIdentities(sub string, identities []GlobusIdentity) (err error) {
 
-	if err != nil {
-		err = fmt.Errorf("%w: %s", errMongoQuery, err.Error())
-		return
+	if u.Sub == "" {
+		u.Sub = sub
 	}
 
-	err = cur.All(mongoCtx, &u)
-	if err != nil {
-		return
-	}
+	identities = append(u.Identities, identities...)
 
-	return
+	if u.ORCID == "" {
+		u.ORCID = orcidFromIdentities(identities)
+	}
 
+	return u.update(bson.D{
+		{"sub", u.Sub},
+		{"orcid", u.ORCID},
+		{"identities", identities},
+	})
 }
 
+// unlinkIdentity removes a linked identity so it can no longer be used to log in,
+// logins with it as the primary identity are refused by findUser even when the rest
+// of their identity set matches. The primary identity cannot be unlinked
+func (u *User) unlinkIdentity(id string) (err error) {
 
-func (u *User) create() (err error) {
-
-	uid, err := uuid.NewRandom()
-	u.ID = uid.String()
-
-	ctx, cancel, client, err := connectMongo()
-	defer cancel()
+	err = u.get()
+	if err == mongo.ErrNoDocuments {
+		err = errNoDocument
+	}
 
 	if err != nil {
-		err = fmt.Errorf("%w: %s", errMongoClient, err.Error())
 		return
 	}
 
-	// connect to the user collection
-	collection := client.Database(mongoDatabase).Collection(mongoCollection)
+	if id == u.Sub {
+		return fmt.Errorf("%w: Cannot Unlink the Primary Identity %s", errModelFieldValidation, id)
+	}
 
-	_, err = collection.InsertOne(ctx, u)
+	identities := []GlobusIdentity{}
+	for _, identity := range u.Identities {
+		if identity.Id != id {
+			identities = append(identities, identity)
+		}
+	}
 
-	if err == nil {
-		return
+	if len(identities) == len(u.Identities) {
+		return fmt.Errorf("%w: Identity %s", errNoDocument, id)
 	}
 
-	if errorDocumentExists(err) {
-		err = errDocumentExists
+	// the orcid iD is only verified while its identity remains linked
+	if u.ORCID != "" && u.ORCID != orcidFromIdentities(identities) {
+		u.ORCID = ""
 	}
 
-	return
+	return u.update(bson.D{
+		{"orcid", u.ORCID},
+		{"identities", identities},
+		{"unlinked_identities", append(u.Unlinked, id)},
+	})
 }
 
+func logoutUser(token string) (u User, err error) {
 
-func (u *User) get() (err error) {
+	query := bson.D{{"access_token", token}, {"@type", typeUser}}
+	update := bson.D{{"$set", bson.D{{"access_token", ""}}  }}
 
 	ctx, cancel, client, err := connectMongo()
 	defer cancel()
 
 	if err != nil {
-		err = fmt.Errorf("%w: %s", errMongoClient, err.Error())
 		return
 	}
 
 	collection := client.Database(mongoDatabase).Collection(mongoCollection)
-	err = collection.FindOne(ctx, bson.D{{"@id", u.ID}}).Decode(&u)
-
-	return
-}
-
-func (u *User) updateToken() (err error) {
 
-	ctx, cancel, client, err := connectMongo()
-	defer cancel()
+	res := collection.FindOneAndUpdate(ctx, query, update)
 
-	// connect to the user collection
-	collection := client.Database(mongoDatabase).Collection(mongoCollection)
+	err = res.Decode(&u)
 
-    filter := bson.D{{"@id", u.ID}}
-    update := bson.D{{"$set", bson.D{{"access_token", u.AccessToken}} }}
+	// TODO: Error Handling For Following Cases
+	// ErrNilDocument
 
-	_, err = collection.UpdateOne(ctx, filter, update)
+	return
 
-    return
 }
 
 
-func (u *User) delete() (err error) {
-
-	ctx, cancel, client, err := connectMongo()
-	defer cancel()
-
-	if err != nil {
-		err = fmt.Errorf("%w: %s", errMongoClient, err.Error())
-		return
-	}
-
-	collection := client.Database(mongoDatabase).Collection(mongoCollection)
-	err = collection.FindOneAndDelete(ctx, bson.D{{"@id", u.ID}}).Decode(&u)
+// userSortFields are the fields users may be sorted by, descending with a leading -
+var userSortFields = []string{"@id", "name", "email"}
 
-	return
+// userFilter selects a page of users, a cursor continues after the last user of the previous page
+type userFilter struct {
+	Role   string
+	Status string
+	Group  string
+	Email  string
+	Name   string
+	Sort   string
+	Limit  int64
+	Cursor *userCursor
 }
 
-// TODO: (MidPriority) Add to User ListAccess()
-// Return Everything a has adequate permissions to access
-/*
-func (u User) listAccess() (r []Resource, err error) {
-
-	// query
-	// query := bson.D{{""}}
-
-	return
+// userCursor is the sort value and id of the last user on a page
+type userCursor struct {
+	Value string `json:"v"`
+	ID    string `json:"id"`
 }
 
-// TODO: (MidPriority) Add to User ListOwned()
-func (u User) listOwned() (r []Resource, err error) {
-	return
+func (c userCursor) encode() string {
+	encoded, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(encoded)
 }
 
-// TODO: (MidPriority) Add to User ListPolicies()
-// Return All Policies effecting this user
-func (u User) listPolicies() (p []Policy, err error) {
+func decodeUserCursor(cursor string) (c *userCursor, err error) {
 
-	return
-}
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+
+	if err == nil {
+		err = json.Unmarshal(decoded, &c)
+	}
+
+	if err != nil || c == nil || c.ID == "" {
+		return nil, fmt.Errorf("%w: Invalid cursor", errModelFieldValidation)
+	}
 
-// TODO: (MidPriority) Add to User ListChallenges()
-// Return All Challenges this User has made
-func (u User) ListChallenges() (c []Challenge, err error) {
 	return
 }
-*/
-
-// UserCreateHandler is the handler for creating a User
-// POST /user/ with JSON body
-func UserCreateHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/ld+json")
 
-	// read and marshal body json into
-	var u User
-	var err error
-	var requestBody []byte
+// parseUserFilter reads the filter from the query parameters
+// role, status, group, email and name prefixes, sort, limit and cursor
+func parseUserFilter(r *http.Request) (f userFilter, err error) {
 
-	requestBody, err = ioutil.ReadAll(r.Body)
+	query := r.URL.Query()
 
+	limit, offset, err := parsePage(r)
 	if err != nil {
-		w.WriteHeader(400)
-		fmt.Fprintf(w, `{"message": "Failed to " ,"error": "%s"}`, err.Error() )
 		return
 	}
 
-	// If Error for Unmarshaling JSON Body
-	if !json.Valid(requestBody) {
-		w.WriteHeader(400)
-		fmt.Fprintf(w, `{"error": "Invalid JSON Submitted"}`)
-		return
+	if offset != 0 {
+		return f, fmt.Errorf("%w: offset is not supported, follow the next link", errModelFieldValidation)
 	}
 
-	err = json.Unmarshal(requestBody, &u)
+	f = userFilter{
+		Role:   strings.ToLower(query.Get("role")),
+		Status: query.Get("status"),
+		Group:  query.Get("group"),
+		Email:  query.Get("email"),
+		Name:   query.Get("name"),
+		Sort:   query.Get("sort"),
+		Limit:  limit,
+	}
 
-	if err != nil {
-		w.WriteHeader(500)
-		fmt.Fprintf(w, `{"message": "Failed to Unmarshal Request JSON", "error": "%s"}`, err.Error() )
-		return
+	if f.Role != "" && !validRole(f.Role) {
+		return f, fmt.Errorf("%w: Invalid Role %s", errModelFieldValidation, f.Role)
 	}
 
-	err = u.create()
+	if f.Status != "" && !contains([]string{statusActive, statusPending, statusSuspended, statusDeleted}, f.Status) {
+		return f, fmt.Errorf("%w: Invalid Status %s", errModelFieldValidation, f.Status)
+	}
 
-	if err == nil {
-		w.WriteHeader(201)
-		createdUser, _ := json.Marshal(u)
-		w.Write(createdUser)
-		return
+	if f.Sort == "" {
+		f.Sort = "@id"
 	}
 
-	// Error for when the User with u.Id Already Exists
-	if errors.Is(err, errDocumentExists) {
-		w.WriteHeader(400)
-		fmt.Fprintf(w, `{"error": "User Already Exists" ,"@id": "%s"}`, u.ID)
-		return
+	if field, _ := f.sortField(); !contains(userSortFields, field) {
+		return f, fmt.Errorf("%w: Cannot Sort by %s", errModelFieldValidation, f.Sort)
 	}
 
-	// Unknown Error catch all
-	w.WriteHeader(500)
-	fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
-	return
+	if cursor := query.Get("cursor"); cursor != "" {
+		f.Cursor, err = decodeUserCursor(cursor)
+	}
 
+	return
 }
 
-// UserListHandler is the handler
-// GET /user/
-func UserListHandler(w http.ResponseWriter, r *http.Request) {
+// sortField is the field sorted on and 1 for ascending or -1 for descending
+func (f userFilter) sortField() (string, int) {
 
-	var err error
-	var userList []User
+	if strings.HasPrefix(f.Sort, "-") {
+		return strings.TrimPrefix(f.Sort, "-"), -1
+	}
 
-	userList, err = listUsers()
+	return f.Sort, 1
+}
+
+// query matches the users of the filter after the cursor, roles are stored lowercase and
+// matched exactly, prefixes are anchored and case sensitive so they can be answered from the indexes
+func (f userFilter) query() bson.D {
+
+	query := bson.D{{"@type", typeUser}}
+
+	if f.Role != "" {
+		query = append(query, bson.E{"role", f.Role})
+	}
+
+	// records without a status predate registration and are active
+	if f.Status == statusActive {
+		query = append(query, bson.E{"status", bson.D{{"$in", bson.A{statusActive, nil}}}})
+	} else if f.Status != "" {
+		query = append(query, bson.E{"status", f.Status})
+	}
+
+	if f.Group != "" {
+		query = append(query, bson.E{"groups", f.Group})
+	}
+
+	if f.Email != "" {
+		query = append(query, bson.E{"email", primitive.Regex{Pattern: "^" + regexp.QuoteMeta(f.Email)}})
+	}
+
+	if f.Name != "" {
+		query = append(query, bson.E{"name", primitive.Regex{Pattern: "^" + regexp.QuoteMeta(f.Name)}})
+	}
+
+	if f.Cursor != nil {
+		field, direction := f.sortField()
+
+		op := "$gt"
+		if direction < 0 {
+			op = "$lt"
+		}
+
+		if field == "@id" {
+			query = append(query, bson.E{"@id", bson.D{{op, f.Cursor.ID}}})
+		} else {
+			query = append(query, bson.E{"$or", bson.A{
+				bson.D{{field, bson.D{{op, f.Cursor.Value}}}},
+				bson.D{{field, f.Cursor.Value}, {"@id", bson.D{{op, f.Cursor.ID}}}},
+			}})
+		}
+	}
+
+	return query
+}
+
+// listUsersPage returns a page of users and the cursor for the next page,
+// the cursor is nil on the last page
+func listUsersPage(f userFilter) (u []User, next *userCursor, err error) {
+
+	mongoCtx, cancel, client, err := connectMongo()
+	defer cancel()
+
+	if err != nil {
+		err = fmt.Errorf("%w: %s", errMongoClient, err.Error())
+		return
+	}
+
+	collection := client.Database(mongoDatabase).Collection(mongoCollection)
+
+	// ties on the sort field are ordered by id so the cursor is unambiguous
+	field, direction := f.sortField()
+	sort := bson.D{{field, direction}}
+	if field != "@id" {
+		sort = append(sort, bson.E{"@id", direction})
+	}
+
+	opts := options.Find().SetSort(sort).SetLimit(f.Limit + 1)
+
+	cur, err := collection.Find(mongoCtx, f.query(), opts)
+	if err != nil {
+		err = fmt.Errorf("%w: %s", errMongoQuery, err.Error())
+		return
+	}
+	defer cur.Close(mongoCtx)
+
+	err = cur.All(mongoCtx, &u)
+	if err != nil {
+		err = fmt.Errorf("%w: %s", errMongoDecode, err.Error())
+		return
+	}
+
+	if int64(len(u)) > f.Limit {
+		u = u[:f.Limit]
+		last := u[len(u)-1]
+		next = &userCursor{ID: last.ID}
+
+		switch field {
+		case "name":
+			next.Value = last.Name
+		case "email":
+			next.Value = last.Email
+		}
+	}
+
+	return
+}
+
+func listUsers() (u []User, err error) {
+
+	mongoCtx, cancel, client, err := connectMongo()
+	defer cancel()
+
+	if err != nil {
+		err = fmt.Errorf("%w: %s", errMongoClient, err.Error())
+		return
+	}
+
+	collection := client.Database(mongoDatabase).Collection(mongoCollection)
+
+	query := bson.D{{"@type", typeUser}}
+	cur, err := collection.Find(mongoCtx, query, nil)
+	defer cur.Close(mongoCtx)
+
+	if err != nil {
+		err = fmt.Errorf("%w: %s", errMongoQuery, err.Error())
+		return
+	}
+
+	err = cur.All(mongoCtx, &u)
+	if err != nil {
+		return
+	}
+
+	return
+
+}
+
+
+// listUsersByID fetches every user record among the ids, unknown ids are skipped
+func listUsersByID(ids []string) (u []User, err error) {
+
+	mongoCtx, cancel, client, err := connectMongo()
+	defer cancel()
+
+	if err != nil {
+		err = fmt.Errorf("%w: %s", errMongoClient, err.Error())
+		return
+	}
+
+	collection := client.Database(mongoDatabase).Collection(mongoCollection)
+
+	orcids := []string{}
+	for _, id := range ids {
+		if orcid, ok := parseORCID(id); ok {
+			orcids = append(orcids, orcid)
+		}
+	}
+
+	query := bson.D{
+		{"@type", typeUser},
+		{"$or", bson.A{
+			bson.D{{"@id", bson.D{{"$in", ids}}}},
+			bson.D{{"orcid", bson.D{{"$in", orcids}}}},
+		}},
+	}
+	cur, err := collection.Find(mongoCtx, query)
+
+	if err != nil {
+		err = fmt.Errorf("%w: %s", errMongoQuery, err.Error())
+		return
+	}
+	defer cur.Close(mongoCtx)
+
+	err = cur.All(mongoCtx, &u)
+	if err != nil {
+		err = fmt.Errorf("%w: %s", errMongoDecode, err.Error())
+	}
+
+	return
+}
+
+
+func (u *User) create() (err error) {
+
+	uid, err := uuid.NewRandom()
+	u.ID = uid.String()
+	u.Role = strings.ToLower(u.Role)
+
+	ctx, cancel, client, err := connectMongo()
+	defer cancel()
+
+	if err != nil {
+		err = fmt.Errorf("%w: %s", errMongoClient, err.Error())
+		return
+	}
+
+	// connect to the user collection
+	collection := client.Database(mongoDatabase).Collection(mongoCollection)
+
+	_, err = collection.InsertOne(ctx, u)
+
+	if err == nil {
+		return
+	}
+
+	if errorDocumentExists(err) {
+		err = errDocumentExists
+	}
+
+	return
+}
+
+
+func (u *User) get() (err error) {
+
+	ctx, cancel, client, err := connectMongo()
+	defer cancel()
+
+	if err != nil {
+		err = fmt.Errorf("%w: %s", errMongoClient, err.Error())
+		return
+	}
+
+	collection := client.Database(mongoDatabase).Collection(mongoCollection)
+	err = collection.FindOne(ctx, bson.D{{"@id", u.ID}}).Decode(&u)
+
+	return
+}
+
+func (u *User) updateToken() (err error) {
+
+	ctx, cancel, client, err := connectMongo()
+	defer cancel()
+
+	// connect to the user collection
+	collection := client.Database(mongoDatabase).Collection(mongoCollection)
+
+    filter := bson.D{{"@id", u.ID}}
+    update := bson.D{{"$set", bson.D{{"access_token", u.AccessToken}} }}
+
+	_, err = collection.UpdateOne(ctx, filter, update)
+
+    return
+}
+
+// BootstrapAdmin gives the user with the email the admin role, creating them if needed,
+// so the first admin can log in through globus and be matched by email
+func BootstrapAdmin(email string) (err error) {
+
+	u, err := queryUserEmail(email)
+
+	if err == errNoDocument {
+		u = User{
+			Type:   typeUser,
+			Email:  email,
+			Role:   roleAdmin,
+			Groups: []string{},
+			Status: statusActive,
+		}
+		return u.create()
+	}
+
+	if err != nil {
+		return
+	}
+
+	if u.hasRole(roleAdmin) {
+		return nil
+	}
+
+	return u.update(bson.D{{"role", roleAdmin}})
+}
+
+// listUsersByStatus returns every user with the given status
+func listUsersByStatus(status string) (u []User, err error) {
+
+	mongoCtx, cancel, client, err := connectMongo()
+	defer cancel()
+
+	if err != nil {
+		err = fmt.Errorf("%w: %s", errMongoClient, err.Error())
+		return
+	}
+
+	collection := client.Database(mongoDatabase).Collection(mongoCollection)
+
+	cur, err := collection.Find(mongoCtx, bson.D{{"@type", typeUser}, {"status", status}})
+	if err != nil {
+		err = fmt.Errorf("%w: %s", errMongoQuery, err.Error())
+		return
+	}
+	defer cur.Close(mongoCtx)
+
+	err = cur.All(mongoCtx, &u)
+	if err != nil {
+		err = fmt.Errorf("%w: %s", errMongoDecode, err.Error())
+	}
+
+	return
+}
+
+// approve activates a pending user so they may log in
+func (u *User) approve() (err error) {
+
+	ctx, cancel, client, err := connectMongo()
+	defer cancel()
+
+	if err != nil {
+		return fmt.Errorf("%w: %s", errMongoClient, err.Error())
+	}
+
+	collection := client.Database(mongoDatabase).Collection(mongoCollection)
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = collection.FindOneAndUpdate(ctx,
+		bson.D{{"@id", u.ID}, {"@type", typeUser}, {"status", statusPending}},
+		bson.D{{"$set", bson.D{{"status", statusActive}}}},
+		opts,
+	).Decode(u)
+
+	if err == mongo.ErrNoDocuments {
+		err = errNoDocument
+	}
+
+	return
+}
+
+// setStatus changes the status of the user and records the change in their history
+func (u *User) setStatus(change StatusChange) (err error) {
+
+	err = change.validate()
+	if err != nil {
+		return
+	}
+
+	if change.Actor == u.ID && change.Status != statusActive {
+		return fmt.Errorf("%w: Users cannot Suspend or Delete Themselves", errModelFieldValidation)
+	}
+
+	ctx, cancel, client, err := connectMongo()
+	defer cancel()
+
+	if err != nil {
+		return fmt.Errorf("%w: %s", errMongoClient, err.Error())
+	}
+
+	collection := client.Database(mongoDatabase).Collection(mongoCollection)
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = collection.FindOneAndUpdate(ctx,
+		bson.D{{"@id", u.ID}, {"@type", typeUser}},
+		bson.D{
+			{"$set", bson.D{{"status", change.Status}}},
+			{"$push", bson.D{{"statusHistory", change}}},
+		},
+		opts,
+	).Decode(u)
+
+	if err == mongo.ErrNoDocuments {
+		err = errNoDocument
+	}
+
+	return
+}
+
+// reject removes a pending user registration
+func (u *User) reject() (err error) {
+
+	ctx, cancel, client, err := connectMongo()
+	defer cancel()
+
+	if err != nil {
+		return fmt.Errorf("%w: %s", errMongoClient, err.Error())
+	}
+
+	collection := client.Database(mongoDatabase).Collection(mongoCollection)
+	err = collection.FindOneAndDelete(ctx,
+		bson.D{{"@id", u.ID}, {"@type", typeUser}, {"status", statusPending}},
+	).Decode(u)
+
+	if err == mongo.ErrNoDocuments {
+		err = errNoDocument
+	}
+
+	return
+}
+
+// update sets the given fields and reloads the user with the result
+func (u *User) update(fields bson.D) (err error) {
+
+	ctx, cancel, client, err := connectMongo()
+	defer cancel()
+
+	if err != nil {
+		err = fmt.Errorf("%w: %s", errMongoClient, err.Error())
+		return
+	}
+
+	collection := client.Database(mongoDatabase).Collection(mongoCollection)
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = collection.FindOneAndUpdate(ctx,
+		bson.D{{"@id", u.ID}, {"@type", typeUser}},
+		bson.D{{"$set", fields}},
+		opts,
+	).Decode(u)
+
+	if err == mongo.ErrNoDocuments {
+		err = errNoDocument
+	}
+
+	if errorDocumentExists(err) {
+		err = errDocumentExists
+	}
+
+	return
+}
+
+// userPatch converts a JSON merge patch into the fields to set on a user
+// only name, email and role may be changed, tokens are never client writable
+func userPatch(patch map[string]json.RawMessage) (fields bson.D, err error) {
+
+	for key, raw := range patch {
+
+		var value *string
+		if err = json.Unmarshal(raw, &value); err != nil {
+			return nil, fmt.Errorf("%w: %s must be a string", errModelFieldValidation, key)
+		}
+
+		switch key {
+		case "name":
+			if value == nil {
+				value = new(string)
+			}
+			fields = append(fields, bson.E{"name", *value})
+
+		case "email":
+			if value == nil || !validEmail(*value) {
+				return nil, fmt.Errorf("%w: email is not a valid address", errModelFieldValidation)
+			}
+			fields = append(fields, bson.E{"email", *value})
+
+		case "role":
+			if value == nil || !validRole(*value) {
+				return nil, fmt.Errorf("%w: role must be one of %s", errModelFieldValidation, strings.Join(validRoles, ", "))
+			}
+			fields = append(fields, bson.E{"role", strings.ToLower(*value)})
+
+		default:
+			return nil, fmt.Errorf("%w: %s may not be modified", errModelFieldValidation, key)
+		}
+	}
+
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("%w: patch contains no changes", errModelMissingField)
+	}
+
+	return
+}
+
+func (u *User) delete() (err error) {
+	_, err = u.deleteTransferring("")
+	return
+}
+
+// UserDeletion reports the references changed when a user was deleted
+type UserDeletion struct {
+	ID                   string `json:"@id"`
+	TransferTo           string `json:"transferTo,omitempty"`
+	ResourcesTransferred int64  `json:"resourcesTransferred"`
+	ResourcesUpdated     int64  `json:"resourcesUpdated"`
+	GroupsTransferred    int64  `json:"groupsTransferred"`
+	GroupsUpdated        int64  `json:"groupsUpdated"`
+	PoliciesUpdated      int64  `json:"policiesUpdated"`
+	PoliciesDeleted      int64  `json:"policiesDeleted"`
+}
+
+// identifiers lists the ids other records may use to reference this user
+func (u User) identifiers() []string {
+
+	ids := []string{u.ID}
+
+	if u.ORCID != "" {
+		ids = append(ids, orcidPrefix+u.ORCID)
+	}
+
+	return ids
+}
+
+// splitPolicies separates the policies that still name another principal once
+// the ids are removed from those that would name no one
+func splitPolicies(policies []Policy, ids []string) (update []string, remove []string) {
+
+	update, remove = []string{}, []string{}
+
+	for _, p := range policies {
+
+		remaining := 0
+		for _, principal := range p.Principal {
+			if !contains(ids, principal) {
+				remaining++
+			}
+		}
+
+		if remaining == 0 {
+			remove = append(remove, p.ID)
+		} else {
+			update = append(update, p.ID)
+		}
+	}
+
+	return
+}
+
+// deleteTransferring deletes the user and every reference to them in a single transaction.
+// Owned resources and administered groups are handed to the transferTo user,
+// when there is something to hand over and no transferTo errOwnershipTransfer is returned.
+// The user is removed from group members, resource users and policy principals,
+// policies left without a principal are deleted. Challenge records are kept for audit.
+// Transactions require mongo to run as a replica set, on a standalone server the steps run
+// without one, the user record is deleted last so a deletion that fails part way can be retried
+func (u *User) deleteTransferring(transferTo string) (d UserDeletion, err error) {
+
+	d.ID = u.ID
+	d.TransferTo = transferTo
+
+	ctx, cancel, client, err := connectMongo()
+	defer cancel()
+
+	if err != nil {
+		err = fmt.Errorf("%w: %s", errMongoClient, err.Error())
+		return
+	}
+
+	collection := client.Database(mongoDatabase).Collection(mongoCollection)
+
+	if !supportsTransactions(ctx, client) {
+		err = u.cascadeDelete(ctx, collection, &d)
+		return
+	}
+
+	session, err := client.StartSession()
+	if err != nil {
+		err = fmt.Errorf("%w: %s", errMongoClient, err.Error())
+		return
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		// the transaction may be retried so each attempt starts a fresh report
+		d = UserDeletion{ID: u.ID, TransferTo: transferTo}
+		return nil, u.cascadeDelete(sc, collection, &d)
+	})
+
+	return
+}
+
+func (u *User) cascadeDelete(ctx context.Context, collection *mongo.Collection, d *UserDeletion) (err error) {
+
+	err = collection.FindOne(ctx, bson.D{{"@id", u.ID}, {"@type", typeUser}}).Decode(u)
+	if err == mongo.ErrNoDocuments {
+		return errNoDocument
+	}
+
+	if err != nil {
+		return fmt.Errorf("%w: %s", errMongoQuery, err.Error())
+	}
+
+	ids := u.identifiers()
+
+	ownedFilter := bson.D{{"@type", typeResource}, {"owner", bson.D{{"$in", ids}}}}
+	adminFilter := bson.D{{"@type", typeGroup}, {"admin", bson.D{{"$in", ids}}}}
+
+	owned, err := collection.CountDocuments(ctx, ownedFilter)
+	if err != nil {
+		return fmt.Errorf("%w: %s", errMongoQuery, err.Error())
+	}
+
+	var groups []Group
+	cur, err := collection.Find(ctx, adminFilter)
+	if err != nil {
+		return fmt.Errorf("%w: %s", errMongoQuery, err.Error())
+	}
+
+	err = cur.All(ctx, &groups)
+	if err != nil {
+		return fmt.Errorf("%w: %s", errMongoDecode, err.Error())
+	}
+
+	if d.TransferTo == "" && (owned != 0 || len(groups) != 0) {
+		return fmt.Errorf("%w: User owns %d Resources and administers %d Groups", errOwnershipTransfer, owned, len(groups))
+	}
+
+	if d.TransferTo != "" {
+
+		if contains(ids, d.TransferTo) {
+			return fmt.Errorf("%w: Cannot Transfer to the Deleted User", errModelFieldValidation)
+		}
+
+		recipient := User{}
+		err = collection.FindOne(ctx, bson.D{{"@id", d.TransferTo}, {"@type", typeUser}}).Decode(&recipient)
+
+		if err == mongo.ErrNoDocuments || (err == nil && !recipient.active()) {
+			return fmt.Errorf("%w: transferTo User %s Not Found", errModelFieldValidation, d.TransferTo)
+		}
+
+		if err != nil {
+			return fmt.Errorf("%w: %s", errMongoQuery, err.Error())
+		}
+
+		result, err := collection.UpdateMany(ctx, ownedFilter, bson.D{{"$set", bson.D{{"owner", d.TransferTo}}}})
+		if err != nil {
+			return fmt.Errorf("%w: %s", errMongoQuery, err.Error())
+		}
+		d.ResourcesTransferred = result.ModifiedCount
+
+		groupIDs := make([]string, len(groups))
+		for i, g := range groups {
+			groupIDs[i] = g.ID
+		}
+
+		// the new admin also becomes a member of each group
+		result, err = collection.UpdateMany(ctx, adminFilter, bson.D{
+			{"$set", bson.D{{"admin", d.TransferTo}}},
+			{"$addToSet", bson.D{{"members", d.TransferTo}}},
+		})
+		if err != nil {
+			return fmt.Errorf("%w: %s", errMongoQuery, err.Error())
+		}
+		d.GroupsTransferred = result.ModifiedCount
+
+		_, err = collection.UpdateOne(ctx,
+			bson.D{{"@id", d.TransferTo}, {"@type", typeUser}},
+			bson.D{{"$addToSet", bson.D{{"groups", bson.D{{"$each", groupIDs}}}}}},
+		)
+		if err != nil {
+			return fmt.Errorf("%w: %s", errMongoQuery, err.Error())
+		}
+	}
+
+	result, err := collection.UpdateMany(ctx,
+		bson.D{{"@type", typeGroup}, {"members", bson.D{{"$in", ids}}}},
+		bson.D{{"$pull", bson.D{{"members", bson.D{{"$in", ids}}}}}},
+	)
+	if err != nil {
+		return fmt.Errorf("%w: %s", errMongoQuery, err.Error())
+	}
+	d.GroupsUpdated = result.ModifiedCount
+
+	result, err = collection.UpdateMany(ctx,
+		bson.D{{"@type", typeResource}, {"users", bson.D{{"$in", ids}}}},
+		bson.D{{"$pull", bson.D{{"users", bson.D{{"$in", ids}}}}}},
+	)
+	if err != nil {
+		return fmt.Errorf("%w: %s", errMongoQuery, err.Error())
+	}
+	d.ResourcesUpdated = result.ModifiedCount
+
+	var policies []Policy
+	cur, err = collection.Find(ctx, bson.D{{"@type", typePolicy}, {"principal", bson.D{{"$in", ids}}}})
+	if err != nil {
+		return fmt.Errorf("%w: %s", errMongoQuery, err.Error())
+	}
+
+	err = cur.All(ctx, &policies)
+	if err != nil {
+		return fmt.Errorf("%w: %s", errMongoDecode, err.Error())
+	}
+
+	update, remove := splitPolicies(policies, ids)
+
+	result, err = collection.UpdateMany(ctx,
+		bson.D{{"@type", typePolicy}, {"@id", bson.D{{"$in", update}}}},
+		bson.D{{"$pull", bson.D{{"principal", bson.D{{"$in", ids}}}}}},
+	)
+	if err != nil {
+		return fmt.Errorf("%w: %s", errMongoQuery, err.Error())
+	}
+	d.PoliciesUpdated = result.ModifiedCount
+
+	deleted, err := collection.DeleteMany(ctx, bson.D{{"@type", typePolicy}, {"@id", bson.D{{"$in", remove}}}})
+	if err != nil {
+		return fmt.Errorf("%w: %s", errMongoQuery, err.Error())
+	}
+	d.PoliciesDeleted = deleted.DeletedCount
+
+	_, err = collection.DeleteOne(ctx, bson.D{{"@id", u.ID}, {"@type", typeUser}})
+	if err != nil {
+		return fmt.Errorf("%w: %s", errMongoQuery, err.Error())
+	}
+
+	return nil
+}
+
+// principals lists every identifier a policy may use to name this user
+// the user id, each group as either a bare id or group:<id>, and the wildcard
+func (u User) principals() []string {
+
+	p := []string{u.ID, "*"}
+
+	if u.ORCID != "" {
+		p = append(p, orcidPrefix+u.ORCID)
+	}
+
+	for _, g := range u.Groups {
+		p = append(p, g, "group:"+g)
+	}
+
+	return p
+}
+
+// owners lists every identifier a resource owner may be recorded as for this user
+func (u User) owners() (o []string) {
+
+	for _, p := range u.principals() {
+		if p != "*" {
+			o = append(o, p)
+		}
+	}
+
+	return
+}
+
+// listAccess returns every resource the user owns or may act on, with the actions allowed
+// through direct policies, group policies and wildcard principals
+func (u User) listAccess() (a []Access, err error) {
+
+	policies, err := u.listPolicies()
+	if err != nil {
+		return
+	}
+
+	var ids, namespaces []string
+	for _, p := range policies {
+		if p.Effect != effectAllow {
+			continue
+		}
+
+		if isNamespacePattern(p.Resource) {
+			namespaces = append(namespaces, p.Resource)
+		} else {
+			ids = append(ids, p.Resource)
+		}
+	}
+
+	resources, err := listResourcesReachable(ids, namespaces, u.owners())
+	if err != nil {
+		return
+	}
+
+	registry, err := listActions("")
+	if err != nil {
+		return
+	}
+
+	a = computeAccess(u, resources, policies, registry, time.Now().UTC())
+
+	return
+}
+
+// listOwned returns a page of the resources owned by the user or one of their groups
+// optionally only resources of one resource type, along with the total number owned
+func (u User) listOwned(resourceType string, limit int64, offset int64) (r []Resource, total int64, err error) {
+
+	mongoCtx, cancel, client, err := connectMongo()
+	defer cancel()
+
+	if err != nil {
+		err = fmt.Errorf("%w: %s", errMongoClient, err.Error())
+		return
+	}
+
+	collection := client.Database(mongoDatabase).Collection(mongoCollection)
+
+	query := bson.D{{"@type", typeResource}, {"owner", bson.D{{"$in", u.owners()}}}}
+	if resourceType != "" {
+		query = append(query, bson.E{"resourceType", resourceType})
+	}
+
+	total, err = collection.CountDocuments(mongoCtx, query)
+	if err != nil {
+		err = fmt.Errorf("%w: %s", errMongoQuery, err.Error())
+		return
+	}
+
+	opts := options.Find().SetSort(bson.D{{"@id", 1}}).SetSkip(offset).SetLimit(limit)
+	cur, err := collection.Find(mongoCtx, query, opts)
+	if err != nil {
+		err = fmt.Errorf("%w: %s", errMongoQuery, err.Error())
+		return
+	}
+	defer cur.Close(mongoCtx)
+
+	err = cur.All(mongoCtx, &r)
+	if err != nil {
+		err = fmt.Errorf("%w: %s", errMongoDecode, err.Error())
+	}
+
+	return
+}
+
+// listPolicies returns every policy naming the user directly, one of their groups, or everyone
+func (u User) listPolicies() (p []Policy, err error) {
+	return listPolicies(policyFilter{Principals: u.principals()})
+}
+
+// listChallenges returns the authorization decisions made about this user by their id or orcid iD
+func (u User) listChallenges(from time.Time, to time.Time) (c []Challenge, err error) {
+	return listChallenges(challengeFilter{Principals: u.identifiers(), From: from, To: to})
+}
+
+// UserCreateHandler is the handler for creating a User
+// POST /user/ with JSON body
+func UserCreateHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/ld+json")
+
+	// read and marshal body json into
+	var u User
+	var err error
+	var requestBody []byte
+
+	requestBody, err = ioutil.ReadAll(r.Body)
+
+	if err != nil {
+		w.WriteHeader(400)
+		fmt.Fprintf(w, `{"message": "Failed to " ,"error": "%s"}`, err.Error() )
+		return
+	}
+
+	// If Error for Unmarshaling JSON Body
+	if !json.Valid(requestBody) {
+		w.WriteHeader(400)
+		fmt.Fprintf(w, `{"error": "Invalid JSON Submitted"}`)
+		return
+	}
+
+	err = json.Unmarshal(requestBody, &u)
+
+	if err != nil {
+		w.WriteHeader(500)
+		fmt.Fprintf(w, `{"message": "Failed to Unmarshal Request JSON", "error": "%s"}`, err.Error() )
+		return
+	}
+
+	err = u.create()
+
+	if err == nil {
+		w.WriteHeader(201)
+		createdUser, _ := json.Marshal(u)
+		w.Write(createdUser)
+		return
+	}
+
+	// Error for when the User with u.Id Already Exists
+	if errors.Is(err, errDocumentExists) {
+		w.WriteHeader(400)
+		fmt.Fprintf(w, `{"error": "User Already Exists" ,"@id": "%s"}`, u.ID)
+		return
+	}
+
+	// Unknown Error catch all
+	w.WriteHeader(500)
+	fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+	return
+
+}
+
+// UserListHandler lists a page of users matching the filters, with a Link header to the next page when more remain
+// GET /user?role=<role>&status=<status>&group=<groupID>&email=<prefix>&name=<prefix>&sort=<-field>&limit=<n>&cursor=<cursor>
+func UserListHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/ld+json")
+
+	filter, err := parseUserFilter(r)
+
+	if err != nil {
+		w.WriteHeader(400)
+		fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+		return
+	}
+
+	userList, cursor, err := listUsersPage(filter)
+
+	if err != nil {
+		w.WriteHeader(500)
+		fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+		return
+	}
+
+	if userList == nil {
+		userList = []User{}
+	}
+
+	// the body stays a bare array for existing clients, the next page is linked in the header
+	if cursor != nil {
+		next := *r.URL
+		query := next.Query()
+		query.Set("limit", strconv.FormatInt(filter.Limit, 10))
+		query.Set("cursor", cursor.encode())
+		next.RawQuery = query.Encode()
+		w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, next.RequestURI()))
+	}
+
+	responseBody, _ := json.Marshal(userList)
+	w.WriteHeader(200)
+	w.Write(responseBody)
+	return
+}
+
+// UserGetHandler gets the details for a single user
+// GET /user/:userID
+func UserGetHandler(w http.ResponseWriter, r *http.Request) {
+	var u User
+	var err error
+
+	// get the user id from the route
+	params := httprouter.ParamsFromContext(r.Context())
+
+	u.ID = params.ByName("userID")
+
+	// GET /user/pending shares the route as httprouter will not register both
+	if u.ID == "pending" {
+		UserPendingListHandler(w, r)
+		return
+	}
+
+	err = u.get()
+
+	if err != nil {
+		return
+	}
+
+	responseBytes, err := json.Marshal(u)
+
+	if err != nil {
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Header().Set("Content-Type", "application/ld+json")
+	w.Write(responseBytes)
+	return
+
+}
+
+// UserPendingListHandler lists registrations awaiting approval
+// GET /user/pending
+func UserPendingListHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/ld+json")
+
+	pending, err := listUsersByStatus(statusPending)
+
+	if err != nil {
+		w.WriteHeader(500)
+		fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+		return
+	}
+
+	if pending == nil {
+		pending = []User{}
+	}
+
+	responseBody, _ := json.Marshal(pending)
+	w.WriteHeader(200)
+	w.Write(responseBody)
+	return
+}
+
+// UserApproveHandler activates a pending registration
+// POST /user/pending/:userID/approve
+func UserApproveHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/ld+json")
+
+	var u User
+
+	params := httprouter.ParamsFromContext(r.Context())
+	u.ID = params.ByName("userID")
+
+	err := u.approve()
+
+	if errors.Is(err, errNoDocument) {
+		w.WriteHeader(404)
+		fmt.Fprintf(w, `{"error": "Pending User Not Found", "@id": "%s"}`, u.ID)
+		return
+	}
+
+	if err != nil {
+		w.WriteHeader(500)
+		fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+		return
+	}
+
+	responseBody, _ := json.Marshal(u)
+	w.WriteHeader(200)
+	w.Write(responseBody)
+	return
+}
+
+// UserRejectHandler removes a pending registration
+// POST /user/pending/:userID/reject
+func UserRejectHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/ld+json")
+
+	var u User
+
+	params := httprouter.ParamsFromContext(r.Context())
+	u.ID = params.ByName("userID")
+
+	err := u.reject()
+
+	if errors.Is(err, errNoDocument) {
+		w.WriteHeader(404)
+		fmt.Fprintf(w, `{"error": "Pending User Not Found", "@id": "%s"}`, u.ID)
+		return
+	}
+
+	if err != nil {
+		w.WriteHeader(500)
+		fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+		return
+	}
+
+	responseBody, _ := json.Marshal(u)
+	w.WriteHeader(200)
+	w.Write(responseBody)
+	return
+}
+
+// UserPatchHandler partially updates a user with JSON merge patch semantics
+// PATCH /user/:userID
+func UserPatchHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/ld+json")
+
+	var u User
+	var patch map[string]json.RawMessage
+
+	params := httprouter.ParamsFromContext(r.Context())
+	u.ID = params.ByName("userID")
+
+	contentType := strings.TrimSpace(strings.Split(r.Header.Get("Content-Type"), ";")[0])
+	if contentType != "" && contentType != "application/merge-patch+json" && contentType != "application/json" {
+		w.WriteHeader(415)
+		fmt.Fprintf(w, `{"error": "Content-Type must be application/merge-patch+json"}`)
+		return
+	}
+
+	requestBody, err := ioutil.ReadAll(r.Body)
+
+	if err != nil {
+		w.WriteHeader(400)
+		fmt.Fprintf(w, `{"error": "Unable to Read Request Body"}`)
+		return
+	}
+
+	err = json.Unmarshal(requestBody, &patch)
+
+	if err != nil {
+		w.WriteHeader(400)
+		fmt.Fprintf(w, `{"error": "Patch must be a JSON Object"}`)
+		return
+	}
+
+	fields, err := userPatch(patch)
+
+	if err != nil {
+		w.WriteHeader(400)
+		fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+		return
+	}
+
+	// only admins may change a role, including their own
+	if caller, ok := callerFromContext(r.Context()); ok && !caller.hasRole(roleAdmin) {
+		for _, f := range fields {
+			if f.Key == "role" {
+				w.WriteHeader(403)
+				fmt.Fprintf(w, `{"error": "Only Admins may Change Roles"}`)
+				return
+			}
+		}
+	}
+
+	err = u.update(fields)
+
+	if errors.Is(err, errNoDocument) {
+		w.WriteHeader(404)
+		fmt.Fprintf(w, `{"error": "User Not Found", "@id": "%s"}`, u.ID)
+		return
+	}
+
+	if errors.Is(err, errDocumentExists) {
+		w.WriteHeader(409)
+		fmt.Fprintf(w, `{"error": "Email Already in Use"}`)
+		return
+	}
+
+	if err != nil {
+		w.WriteHeader(500)
+		fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+		return
+	}
+
+	responseBody, _ := json.Marshal(u)
+	w.WriteHeader(200)
+	w.Write(responseBody)
+	return
+}
+
+// UserChallengesHandler lists the authorization decisions made about a user
+// GET /user/:userID/challenges?from=<RFC3339>&to=<RFC3339>
+func UserChallengesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/ld+json")
+
+	var u User
+
+	params := httprouter.ParamsFromContext(r.Context())
+	u.ID = params.ByName("userID")
+
+	filter, err := parseChallengeFilter(r)
 
 	if err != nil {
+		w.WriteHeader(400)
+		fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
 		return
 	}
 
-	var responseBody []byte
-	responseBody, err = json.Marshal(userList)
+	// the user is loaded so challenges made by their orcid iD are found too
+	err = u.get()
+
+	if err == mongo.ErrNoDocuments {
+		w.WriteHeader(404)
+		fmt.Fprintf(w, `{"error": "User Not Found", "@id": "%s"}`, u.ID)
+		return
+	}
 
 	if err != nil {
+		w.WriteHeader(500)
+		fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
 		return
 	}
 
-	w.WriteHeader(200)
+	challenges, err := u.listChallenges(filter.From, filter.To)
+	writeChallenges(w, challenges, err)
+}
+
+// UserAccessHandler lists every resource a user may act on and the actions allowed
+// GET /user/:userID/access
+func UserAccessHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/ld+json")
-	w.Write(responseBody)
 
+	var u User
+
+	params := httprouter.ParamsFromContext(r.Context())
+	u.ID = params.ByName("userID")
+
+	err := u.get()
+
+	if err == mongo.ErrNoDocuments {
+		w.WriteHeader(404)
+		fmt.Fprintf(w, `{"error": "User Not Found", "@id": "%s"}`, u.ID)
+		return
+	}
+
+	if err != nil {
+		w.WriteHeader(500)
+		fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+		return
+	}
+
+	access, err := u.listAccess()
+
+	if err != nil {
+		w.WriteHeader(500)
+		fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+		return
+	}
+
+	if access == nil {
+		access = []Access{}
+	}
+
+	responseBody, _ := json.Marshal(access)
+	w.WriteHeader(200)
+	w.Write(responseBody)
 	return
 }
 
-// UserGetHandler gets the details for a single user
-// GET /user/:userID
-func UserGetHandler(w http.ResponseWriter, r *http.Request) {
+// UserOwnedHandler lists a page of the resources a user owns directly or through their groups
+// GET /user/:userID/owned?type=<resourceType>&limit=<n>&offset=<n>
+func UserOwnedHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/ld+json")
+
 	var u User
-	var err error
 
-	// get the user id from the route
 	params := httprouter.ParamsFromContext(r.Context())
-
 	u.ID = params.ByName("userID")
+
+	limit, offset, err := parsePage(r)
+
+	if err != nil {
+		w.WriteHeader(400)
+		fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+		return
+	}
+
 	err = u.get()
 
+	if err == mongo.ErrNoDocuments {
+		w.WriteHeader(404)
+		fmt.Fprintf(w, `{"error": "User Not Found", "@id": "%s"}`, u.ID)
+		return
+	}
+
 	if err != nil {
+		w.WriteHeader(500)
+		fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
 		return
 	}
 
-	responseBytes, err := json.Marshal(u)
+	resourceType := r.URL.Query().Get("type")
+	owned, total, err := u.listOwned(resourceType, limit, offset)
 
 	if err != nil {
+		w.WriteHeader(500)
+		fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
 		return
 	}
 
+	if owned == nil {
+		owned = []Resource{}
+	}
+
+	response := map[string]interface{}{
+		"resources": owned,
+		"total":     total,
+	}
+
+	if offset+int64(len(owned)) < total {
+		next := *r.URL
+		query := next.Query()
+		query.Set("limit", strconv.FormatInt(limit, 10))
+		query.Set("offset", strconv.FormatInt(offset+limit, 10))
+		next.RawQuery = query.Encode()
+		response["next"] = next.RequestURI()
+	}
+
+	responseBody, _ := json.Marshal(response)
 	w.WriteHeader(200)
+	w.Write(responseBody)
+	return
+}
+
+// UserPoliciesHandler lists every policy affecting a user split by effect
+// GET /user/:userID/policies
+func UserPoliciesHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/ld+json")
-	w.Write(responseBytes)
+
+	var u User
+
+	params := httprouter.ParamsFromContext(r.Context())
+	u.ID = params.ByName("userID")
+
+	err := u.get()
+
+	if err == mongo.ErrNoDocuments {
+		w.WriteHeader(404)
+		fmt.Fprintf(w, `{"error": "User Not Found", "@id": "%s"}`, u.ID)
+		return
+	}
+
+	if err != nil {
+		w.WriteHeader(500)
+		fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+		return
+	}
+
+	policies, err := u.listPolicies()
+
+	if err != nil {
+		w.WriteHeader(500)
+		fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+		return
+	}
+
+	allow, deny := []Policy{}, []Policy{}
+
+	for _, p := range policies {
+		if p.Effect == effectDeny {
+			deny = append(deny, p)
+		} else {
+			allow = append(allow, p)
+		}
+	}
+
+	responseBody, _ := json.Marshal(map[string][]Policy{"allow": allow, "deny": deny})
+	w.WriteHeader(200)
+	w.Write(responseBody)
 	return
+}
 
+// MeGroup is a group of the current user with its name
+type MeGroup struct {
+	ID    string `json:"@id"`
+	Name  string `json:"name"`
+	Admin bool   `json:"admin"`
 }
 
-// User Delete Handler
-// DELETE /user/:userID
-func UserDeleteHandler(w http.ResponseWriter, r *http.Request) {
-	var u User
+// Me is the profile of the current user and the expiry of their session
+type Me struct {
+	ID      string    `json:"@id"`
+	Type    string    `json:"@type"`
+	Name    string    `json:"name"`
+	Email   string    `json:"email"`
+	Role    string    `json:"role"`
+	Status  string    `json:"status,omitempty"`
+	ORCID   string    `json:"orcid,omitempty"`
+	Groups  []MeGroup `json:"groups"`
+	Expires time.Time `json:"expires"`
+}
+
+// UserMeHandler returns the profile of the user the session token was issued to
+// GET /me
+func UserMeHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/ld+json")
+
+	p, ok := PrincipalFromContext(r.Context())
+
 	var err error
+	if !ok {
+		p, err = authenticate(r)
+	}
+
+	if errors.Is(err, errTokenMissing) || errors.Is(err, errTokenInvalid) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		w.WriteHeader(401)
+		fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+		return
+	}
+
+	if err != nil {
+		w.WriteHeader(500)
+		fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+		return
+	}
+
+	u := p.User
+
+	var groups []Group
+	if len(u.Groups) != 0 {
+		groups, err = listGroupsByID(u.Groups)
+	}
+
+	if err != nil {
+		w.WriteHeader(500)
+		fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+		return
+	}
+
+	me := Me{
+		ID:      u.ID,
+		Type:    u.Type,
+		Name:    u.Name,
+		Email:   u.Email,
+		Role:    u.Role,
+		Status:  u.Status,
+		ORCID:   u.ORCID,
+		Groups:  make([]MeGroup, len(groups)),
+		Expires: p.ExpiresAt,
+	}
+
+	for i, g := range groups {
+		me.Groups[i] = MeGroup{ID: g.ID, Name: g.Name, Admin: g.Admin == u.ID}
+	}
+
+	responseBody, _ := json.Marshal(me)
+	w.WriteHeader(200)
+	w.Write(responseBody)
+	return
+}
+
+// UserStatusHandler suspends, reactivates or marks a user deleted, recording the reason and acting admin
+// PUT /user/:userID/status {"status": "suspended", "reason": "..."}
+func UserStatusHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/ld+json")
+
+	var u User
+	var change StatusChange
 
-	// get the user id from the route
 	params := httprouter.ParamsFromContext(r.Context())
+	u.ID = params.ByName("userID")
+
+	requestBody, err := ioutil.ReadAll(r.Body)
+
+	if err != nil {
+		w.WriteHeader(400)
+		fmt.Fprintf(w, `{"error": "Unable to Read Request Body"}`)
+		return
+	}
+
+	err = json.Unmarshal(requestBody, &change)
+
+	if err != nil {
+		w.WriteHeader(400)
+		fmt.Fprintf(w, `{"message": "Failed to Unmarshal Request JSON", "error": "%s"}`, err.Error())
+		return
+	}
+
+	change.Actor = ""
+	if caller, ok := callerFromContext(r.Context()); ok {
+		change.Actor = caller.ID
+	}
+	change.Timestamp = time.Now().UTC()
+
+	err = u.setStatus(change)
+
+	if errors.Is(err, errModelFieldValidation) {
+		w.WriteHeader(400)
+		fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+		return
+	}
+
+	if errors.Is(err, errNoDocument) {
+		w.WriteHeader(404)
+		fmt.Fprintf(w, `{"error": "User Not Found", "@id": "%s"}`, u.ID)
+		return
+	}
+
+	if err != nil {
+		w.WriteHeader(500)
+		fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+		return
+	}
+
+	responseBody, _ := json.Marshal(u)
+	w.WriteHeader(200)
+	w.Write(responseBody)
+	return
+}
 
+// UserIdentitiesHandler lists the globus identities linked to a user
+// GET /user/:userID/identities
+func UserIdentitiesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/ld+json")
+
+	var u User
+
+	params := httprouter.ParamsFromContext(r.Context())
 	u.ID = params.ByName("userID")
-	err = u.delete()
+
+	err := u.get()
+
+	if err == mongo.ErrNoDocuments {
+		w.WriteHeader(404)
+		fmt.Fprintf(w, `{"error": "User Not Found", "@id": "%s"}`, u.ID)
+		return
+	}
 
 	if err != nil {
 		w.WriteHeader(500)
+		fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
 		return
 	}
 
-	responseBytes, err := json.Marshal(u)
+	if u.Identities == nil {
+		u.Identities = []GlobusIdentity{}
+	}
+
+	responseBody, _ := json.Marshal(map[string]interface{}{
+		"sub":        u.Sub,
+		"identities": u.Identities,
+	})
+	w.WriteHeader(200)
+	w.Write(responseBody)
+	return
+}
+
+// UserUnlinkIdentityHandler unlinks a globus identity from a user
+// DELETE /user/:userID/identities/:identityID
+func UserUnlinkIdentityHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/ld+json")
+
+	var u User
+
+	params := httprouter.ParamsFromContext(r.Context())
+	u.ID = params.ByName("userID")
+
+	err := u.unlinkIdentity(params.ByName("identityID"))
+
+	if errors.Is(err, errModelFieldValidation) {
+		w.WriteHeader(400)
+		fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+		return
+	}
+
+	if errors.Is(err, errNoDocument) {
+		w.WriteHeader(404)
+		fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+		return
+	}
 
 	if err != nil {
 		w.WriteHeader(500)
+		fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
 		return
 	}
 
+	responseBody, _ := json.Marshal(u)
 	w.WriteHeader(200)
+	w.Write(responseBody)
+	return
+}
+
+// UserDeleteHandler deletes a user and every reference to them, resources and groups
+// they own are handed to the transferTo user
+// DELETE /user/:userID?transferTo=<userID>
+func UserDeleteHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/ld+json")
-	w.Write(responseBytes)
+
+	var u User
+
+	// get the user id from the route
+	params := httprouter.ParamsFromContext(r.Context())
+	u.ID = params.ByName("userID")
+
+	deletion, err := u.deleteTransferring(r.URL.Query().Get("transferTo"))
+
+	if errors.Is(err, errNoDocument) {
+		w.WriteHeader(404)
+		fmt.Fprintf(w, `{"error": "User Not Found", "@id": "%s"}`, u.ID)
+		return
+	}
+
+	if errors.Is(err, errOwnershipTransfer) {
+		w.WriteHeader(409)
+		fmt.Fprintf(w, `{"error": "%s", "message": "transferTo Required"}`, err.Error())
+		return
+	}
+
+	if errors.Is(err, errModelFieldValidation) {
+		w.WriteHeader(400)
+		fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+		return
+	}
+
+	if err != nil {
+		w.WriteHeader(500)
+		fmt.Fprintf(w, `{"error": "%s"}`, err.Error())
+		return
+	}
+
+	responseBody, _ := json.Marshal(deletion)
+	w.WriteHeader(200)
+	w.Write(responseBody)
 	return
 
 }
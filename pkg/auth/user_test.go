@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"io/ioutil"
+	"net/http"
 	"net/http/httptest"
 )
 
@@ -267,3 +268,291 @@ func TestUserJSONMarshal(t *testing.T) {
 	t.Logf("MarshaledUser: %s", string(userJSON))
 
 }
+
+func TestUserPatch(t *testing.T) {
+
+	patch := func(body string) map[string]json.RawMessage {
+		var p map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(body), &p); err != nil {
+			t.Fatalf("Invalid Test Patch: %s", err.Error())
+		}
+		return p
+	}
+
+	t.Run("Valid", func(t *testing.T) {
+		fields, err := userPatch(patch(`{"name": null, "email": "joe@example.org", "role": "Curator"}`))
+		if err != nil {
+			t.Fatalf("Valid Patch Rejected: %s", err.Error())
+		}
+
+		if len(fields) != 3 {
+			t.Fatalf("Patch Fields Missing: %+v", fields)
+		}
+
+		for _, f := range fields {
+			if f.Key == "role" && f.Value != roleCurator {
+				t.Errorf("Role not Normalized: %+v", f)
+			}
+		}
+	})
+
+	for name, body := range map[string]string{
+		"AccessToken":  `{"access_token": "abcd"}`,
+		"RefreshToken": `{"refresh_token": "abcd"}`,
+		"Groups":       `{"groups": ["g1"]}`,
+		"InvalidRole":  `{"role": "superuser"}`,
+		"InvalidEmail": `{"email": "jschm@exampleorg"}`,
+		"NullEmail":    `{"email": null}`,
+		"Empty":        `{}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := userPatch(patch(body)); err == nil {
+				t.Fatalf("Invalid Patch Accepted: %s", body)
+			}
+		})
+	}
+
+}
+
+func TestValidEmail(t *testing.T) {
+
+	for _, email := range []string{"jschmoe@example.org", "j.schmoe+auth@virginia.edu"} {
+		if !validEmail(email) {
+			t.Errorf("Valid Email Rejected: %s", email)
+		}
+	}
+
+	for _, email := range []string{"jschmexample.org", "jschmexampleorg", "jschm@exampleorg", "jschm@@example..org", "Joe <joe@example.org>"} {
+		if validEmail(email) {
+			t.Errorf("Invalid Email Accepted: %s", email)
+		}
+	}
+
+}
+
+func TestUserIdentities(t *testing.T) {
+
+	intro := GlobusIntrospectedToken{
+		Sub:           "primary",
+		IdentitiesSet: []string{"orcid", "primary", "unlinked", "linked"},
+	}
+
+	ids := intro.identityIDs()
+	if len(ids) != 4 || ids[0] != "primary" {
+		t.Fatalf("Identity IDs Incorrect: %v", ids)
+	}
+
+	u := User{
+		Sub:        "primary",
+		Identities: []GlobusIdentity{{Id: "primary"}, {Id: "linked"}},
+		Unlinked:   []string{"unlinked"},
+	}
+
+	missing := u.missingIdentities(ids)
+	if len(missing) != 1 || missing[0] != "orcid" {
+		t.Fatalf("Missing Identities Incorrect: %v", missing)
+	}
+
+	if intro.unlinkedFrom(u) {
+		t.Fatalf("Login with a Linked Primary Identity Refused")
+	}
+
+	// the unlinked identity remains in globus with the user's other identities
+	unlinked := GlobusIntrospectedToken{Sub: "unlinked", IdentitiesSet: []string{"unlinked", "primary", "linked"}}
+	if !unlinked.unlinkedFrom(u) {
+		t.Fatalf("Login with an Unlinked Primary Identity Accepted")
+	}
+
+}
+
+func TestUserORCID(t *testing.T) {
+
+	for principal, expected := range map[string]string{
+		"orcid:0000-0002-1825-009x":             "orcid:0000-0002-1825-009X",
+		"ORCID:0000-0002-1825-0097":             "orcid:0000-0002-1825-0097",
+		"https://orcid.org/0000-0002-1825-0097": "orcid:0000-0002-1825-0097",
+		"orcid:1234-1234":                       "orcid:1234-1234",
+		"group:0000-0002-1825-0097":             "group:0000-0002-1825-0097",
+	} {
+		if normalized := normalizePrincipal(principal); normalized != expected {
+			t.Errorf("Principal %s Normalized to %s not %s", principal, normalized, expected)
+		}
+	}
+
+	identities := []GlobusIdentity{
+		{Id: "1", Username: "jschmoe@virginia.edu"},
+		{Id: "2", Username: "0000-0002-1825-0097@orcid.org"},
+	}
+
+	if orcid := orcidFromIdentities(identities); orcid != "0000-0002-1825-0097" {
+		t.Errorf("ORCID not Found in Identities: %s", orcid)
+	}
+
+	u := User{ID: "1", ORCID: "0000-0002-1825-0097"}
+	if !contains(u.principals(), "orcid:0000-0002-1825-0097") {
+		t.Errorf("ORCID Missing From Principals: %v", u.principals())
+	}
+
+}
+
+func TestSplitPolicies(t *testing.T) {
+
+	ids := User{ID: "deleted", ORCID: "0000-0002-1825-0097"}.identifiers()
+
+	policies := []Policy{
+		{ID: "shared", Principal: []string{"deleted", "group:g1"}},
+		{ID: "orcid", Principal: []string{"orcid:0000-0002-1825-0097"}},
+		{ID: "both", Principal: []string{"deleted", "orcid:0000-0002-1825-0097"}},
+	}
+
+	update, remove := splitPolicies(policies, ids)
+
+	if len(update) != 1 || update[0] != "shared" {
+		t.Errorf("Policies to Update Incorrect: %v", update)
+	}
+
+	if len(remove) != 2 || remove[0] != "orcid" || remove[1] != "both" {
+		t.Errorf("Policies to Remove Incorrect: %v", remove)
+	}
+
+}
+
+func TestUserFilter(t *testing.T) {
+
+	t.Run("Valid", func(t *testing.T) {
+		cursor := userCursor{Value: "Joe Schmoe", ID: "u1"}.encode()
+		request := httptest.NewRequest("GET", "http://localhost:8080/user?role=Admin&name=Jo&sort=-name&limit=10&cursor="+cursor, nil)
+
+		f, err := parseUserFilter(request)
+		if err != nil {
+			t.Fatalf("Valid Filter Rejected: %s", err.Error())
+		}
+
+		if f.Role != roleAdmin || f.Limit != 10 || f.Cursor == nil || f.Cursor.Value != "Joe Schmoe" {
+			t.Fatalf("Filter Parsed Incorrectly: %+v", f)
+		}
+
+		if field, direction := f.sortField(); field != "name" || direction != -1 {
+			t.Errorf("Sort Parsed Incorrectly: %s %d", field, direction)
+		}
+
+		// type, role, name and the cursor
+		if query := f.query(); len(query) != 4 || query[3].Key != "$or" {
+			t.Errorf("Query Incorrect: %+v", query)
+		}
+
+		// roles are stored lowercase and matched exactly so the role index is used
+		if role := f.query()[1]; role.Key != "role" || role.Value != roleAdmin {
+			t.Errorf("Role not Matched Exactly: %+v", role)
+		}
+	})
+
+	t.Run("DefaultSort", func(t *testing.T) {
+		f, err := parseUserFilter(httptest.NewRequest("GET", "http://localhost:8080/user", nil))
+		if err != nil || f.Sort != "@id" || f.Limit != defaultPageLimit {
+			t.Fatalf("Default Filter Incorrect: %+v %v", f, err)
+		}
+	})
+
+	for name, query := range map[string]string{
+		"InvalidRole":   "role=superuser",
+		"InvalidSort":   "sort=access_token",
+		"InvalidCursor": "cursor=abcd",
+		"Offset":        "offset=10",
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := parseUserFilter(httptest.NewRequest("GET", "http://localhost:8080/user?"+query, nil)); err == nil {
+				t.Fatalf("Invalid Filter Accepted: %s", query)
+			}
+		})
+	}
+
+}
+
+func TestUserStatus(t *testing.T) {
+
+	if !(User{}).active() || !(User{Status: statusActive}).active() {
+		t.Errorf("Active Users Refused")
+	}
+
+	for _, status := range []string{statusPending, statusSuspended, statusDeleted} {
+		if (User{Status: status}).active() {
+			t.Errorf("%s User Treated as Active", status)
+		}
+	}
+
+	for name, change := range map[string]StatusChange{
+		"Pending":         {Status: statusPending, Reason: "re-register"},
+		"Unknown":         {Status: "banned", Reason: "spam"},
+		"SuspendNoReason": {Status: statusSuspended},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if err := change.validate(); err == nil {
+				t.Errorf("Invalid Status Change Accepted: %+v", change)
+			}
+		})
+	}
+
+	t.Run("SuspendSelf", func(t *testing.T) {
+		admin := User{ID: "admin"}
+		err := admin.setStatus(StatusChange{Status: statusSuspended, Reason: "test", Actor: "admin"})
+		if err == nil {
+			t.Errorf("Admin Suspended Themselves")
+		}
+	})
+
+	t.Run("Reactivate", func(t *testing.T) {
+		if err := (StatusChange{Status: statusActive}).validate(); err != nil {
+			t.Errorf("Reactivation Rejected: %s", err.Error())
+		}
+	})
+
+}
+
+func TestUserMeHandler(t *testing.T) {
+
+	t.Run("RequestToken", func(t *testing.T) {
+		request := httptest.NewRequest("GET", "http://localhost:8080/me", nil)
+		request.AddCookie(&http.Cookie{Name: "fairscapeAuth", Value: "cookie"})
+
+		if token := requestToken(request); token != "cookie" {
+			t.Errorf("Cookie Token not Read: %s", token)
+		}
+
+		request.Header.Set("Authorization", "Bearer header")
+		if token := requestToken(request); token != "header" {
+			t.Errorf("Authorization Header not Preferred: %s", token)
+		}
+	})
+
+	t.Run("CookieOnlyForSafeMethods", func(t *testing.T) {
+		request := httptest.NewRequest("DELETE", "http://localhost:8080/user/member", nil)
+		request.AddCookie(&http.Cookie{Name: "fairscapeAuth", Value: "cookie"})
+
+		if token := requestToken(request); token != "" {
+			t.Errorf("Cookie Token Read on a DELETE: %s", token)
+		}
+
+		request.Header.Set("Authorization", "Bearer header")
+		if token := requestToken(request); token != "header" {
+			t.Errorf("Authorization Header not Read: %s", token)
+		}
+	})
+
+	for name, token := range map[string]string{"Missing": "", "Invalid": "abcd"} {
+		t.Run(name, func(t *testing.T) {
+			request := httptest.NewRequest("GET", "http://localhost:8080/me", nil)
+			if token != "" {
+				request.Header.Set("Authorization", "Bearer "+token)
+			}
+
+			rr := httptest.NewRecorder()
+			UserMeHandler(rr, request)
+
+			if rr.Code != 401 {
+				t.Errorf("StatusCode: %d \nBody: %s", rr.Code, rr.Body.String())
+			}
+		})
+	}
+
+}
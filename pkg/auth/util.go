@@ -1,11 +1,14 @@
 package auth
 
 import (
-	//"net/http"
+	"net/http"
 	"context"
 	"errors"
+	"fmt"
 	"reflect"
+	"strconv"
 	"time"
+	bson "go.mongodb.org/mongo-driver/bson"
 	mongo "go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"os"
@@ -30,6 +33,8 @@ var (
 	errJSONUnmarshal        = errors.New("ErrorParsingJSON")
 	errUUID                 = errors.New("ErrorCreatingUUID")
 	errRegex                = errors.New("ErrorRunningRegex")
+	errOwnershipTransfer    = errors.New("ErrorOwnershipTransferRequired")
+	errIdentityUnlinked     = errors.New("ErrorIdentityUnlinked")
 )
 
 var (
@@ -40,15 +45,49 @@ var (
 )
 
 const (
-	typeGroup     = "Organization"
-	typeUser      = "Person"
-	typeResource  = "Resource"
-	typePolicy    = "Policy"
-	typeChallenge = "Challenge"
+	typeGroup      = "Organization"
+	typeUser       = "Person"
+	typeResource   = "Resource"
+	typePolicy     = "Policy"
+	typeChallenge  = "Challenge"
+	typeAction     = "Action"
+	typeSigningKey = "SigningKey"
 )
 
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 500
+)
+
+// parsePage reads the limit and offset query parameters for paginated listings
+func parsePage(r *http.Request) (limit int64, offset int64, err error) {
+
+	limit = defaultPageLimit
+	query := r.URL.Query()
+
+	if l := query.Get("limit"); l != "" {
+		limit, err = strconv.ParseInt(l, 10, 64)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			return 0, 0, fmt.Errorf("%w: limit must be between 1 and %d", errModelFieldValidation, maxPageLimit)
+		}
+	}
+
+	if o := query.Get("offset"); o != "" {
+		offset, err = strconv.ParseInt(o, 10, 64)
+		if err != nil || offset < 0 {
+			return 0, 0, fmt.Errorf("%w: offset must be a positive integer", errModelFieldValidation)
+		}
+	}
+
+	return
+}
+
 func connectMongo() (ctx context.Context, cancel context.CancelFunc, client *mongo.Client, err error) {
 
+	// create a context for the connection
+	// created first so callers may always defer cancel
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Second)
+
 	// establish connection with mongo backend
 	client, err = mongo.NewClient(options.Client().ApplyURI(mongoURI))
 	if err != nil {
@@ -57,9 +96,6 @@ func connectMongo() (ctx context.Context, cancel context.CancelFunc, client *mon
 		return
 	}
 
-	// create a context for the connection
-	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Second)
-
 	// connect to the client
 	err = client.Connect(ctx)
 
@@ -70,6 +106,31 @@ func connectMongo() (ctx context.Context, cancel context.CancelFunc, client *mon
 	return
 }
 
+// supportsTransactions reports if the server is a replica set member or a sharded router,
+// standalone servers cannot run transactions
+func supportsTransactions(ctx context.Context, client *mongo.Client) bool {
+
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+
+	err := client.Database("admin").RunCommand(ctx, bson.D{{"isMaster", 1}}).Decode(&hello)
+	if err != nil {
+		mongoLogger.Error().Err(err).Msg("Failed to Describe Server")
+		return false
+	}
+
+	return hello.SetName != "" || hello.Msg == "isdbgrid"
+}
+
+// CreateIndexes builds the mongo indexes the models rely on, it is safe to call on every start
+func CreateIndexes() {
+	createUserIndex()
+	createResourceIndex()
+	createChallengeIndex()
+}
+
 // ErrorDocumentExists determines if an error from the mongo server is a MongoWriteError
 // where the document already exists and collides on at least one unique index
 // Need to crack open the returned errors
@@ -93,6 +154,11 @@ func errorDocumentExists(err error) bool {
 		writeError = err.(mongo.WriteException).WriteErrors[0]
 	}
 
+	// findAndModify reports duplicate keys as a command error
+	if errorName == "CommandError" {
+		return err.(mongo.CommandError).Code == 11000
+	}
+
 	if errorName != "WriteErrors" && errorName != "WriteException" {
 		return false
 	}
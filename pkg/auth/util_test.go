@@ -3,6 +3,7 @@ package auth
 import (
 	"testing"
 	"errors"
+	"net/http/httptest"
 	mongo "go.mongodb.org/mongo-driver/mongo"
 )
 
@@ -22,4 +23,29 @@ func TestErrorDocuments(t *testing.T) {
 		t.Errorf("ErrorDocumentExists fails to detect correct error ")
 	}
 
+	if !errorDocumentExists(mongo.CommandError{Code: 11000}) {
+		t.Errorf("ErrorDocumentExists fails to detect duplicate key from findAndModify")
+	}
+
+}
+
+func TestParsePage(t *testing.T) {
+
+	limit, offset, err := parsePage(httptest.NewRequest("GET", "http://localhost:8080/user/u1/owned", nil))
+	if err != nil || limit != defaultPageLimit || offset != 0 {
+		t.Fatalf("Default Page Incorrect: %d %d %v", limit, offset, err)
+	}
+
+	limit, offset, err = parsePage(httptest.NewRequest("GET", "http://localhost:8080/user/u1/owned?limit=10&offset=20", nil))
+	if err != nil || limit != 10 || offset != 20 {
+		t.Fatalf("Page Incorrect: %d %d %v", limit, offset, err)
+	}
+
+	for _, query := range []string{"limit=0", "limit=100000", "offset=-1", "limit=ten"} {
+		_, _, err = parsePage(httptest.NewRequest("GET", "http://localhost:8080/user/u1/owned?"+query, nil))
+		if err == nil {
+			t.Errorf("Invalid Page Accepted: %s", query)
+		}
+	}
+
 }